@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// --- ADVENTURER ---
+// An adventurer is the content of an Adventure Sheet, kept in memory.
+// It starts from one of the professions of Adventurers.xml, and is saved as JSON.
+
+const ADVENTURER_SCHEMA = "jafl-to-html/adventurer"
+const ADVENTURER_VERSION = 1
+
+type Adventurer struct {
+	Schema string `json:"schema"`
+	Version int `json:"version"`
+	Name string `json:"name"`
+	Profession string `json:"profession"`
+	God string `json:"god,omitempty"`
+	Rank int `json:"rank"`
+	Stamina int `json:"stamina"`
+	MaxStamina int `json:"maxStamina"`
+	Shards int `json:"shards"`
+	Abilities map[string]int `json:"abilities"`
+	Possessions []Item `json:"possessions,omitempty"`
+	Codewords []string `json:"codewords,omitempty"`
+	Titles []string `json:"titles,omitempty"`
+	Blessings []string `json:"blessings,omitempty"`
+	Resurrection string `json:"resurrection,omitempty"`
+	Temple *Target `json:"temple,omitempty"`	// Section of the temple where resurrection was arranged
+	Ships []Ship `json:"ships,omitempty"`
+	Ticks map[string]int `json:"ticks,omitempty"`
+	Section string `json:"section,omitempty"`
+	Seed uint64 `json:"seed,omitempty"`
+}
+
+type Ship struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+	Crew string `json:"crew,omitempty"`
+	Cargo []string `json:"cargo,omitempty"`
+	Docked string `json:"docked,omitempty"`
+}
+
+// Cargo capacity of each ship type, which the game keeps track of instead of the books
+var SHIP_CAPACITY = map[string]int{"Barque": 1, "Brigantine": 2, "Galleon": 3}
+
+func newAdventurer(p Profession) (a Adventurer) {
+	a.Schema, a.Version = ADVENTURER_SCHEMA, ADVENTURER_VERSION
+	a.Name = p.PersonName
+	a.Profession = p.Name
+	a.Rank = atoi(p.Rank)
+	a.Stamina = atoi(p.Stamina)
+	a.MaxStamina = a.Stamina
+	a.Shards = atoi(p.Gold)
+	a.Abilities = make(map[string]int)
+	for i, v := range p.Abilities {
+		if i < len(ABILITIES) {
+			a.Abilities[ABILITIES[i]] = atoi(v)
+		}
+	}
+	a.Possessions = slices.Clone(p.Equipment)
+	a.Ticks = make(map[string]int)
+	return
+}
+
+func loadAdventurer(filename string) (a Adventurer, err error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &a); err != nil {
+		return
+	}
+	if a.Schema != ADVENTURER_SCHEMA {
+		err = fmt.Errorf("%s is not a saved adventurer", filename)
+	}
+	if a.Abilities == nil {
+		a.Abilities = make(map[string]int)
+	}
+	if a.Ticks == nil {
+		a.Ticks = make(map[string]int)
+	}
+	return
+}
+
+// abilityName finds the spelling of ABILITIES for an ability written in any case.
+func abilityName(s string) string {
+	for _, a := range ABILITIES {
+		if strings.EqualFold(a, s) {
+			return a
+		}
+	}
+	return ""
+}
+
+// bonus is the best bonus the possessions of a kind give to an ability.
+func (a Adventurer) bonus(kind, ability string) (best int) {
+	for _, p := range a.Possessions {
+		if p.Type == kind && (ability == "" || strings.EqualFold(p.Ability, ability)) {
+			best = max(best, atoi(p.Bonus))
+		}
+	}
+	return
+}
+
+// Ability is the score of an ability, with the bonus of the best weapon or tool for it.
+func (a Adventurer) Ability(name string) int {
+	name = abilityName(name)
+	score := a.Abilities[name]
+	if name == "Combat" {
+		score += a.bonus("weapon", "")
+	}
+	return score + max(a.bonus("tool", name), a.bonus("item", name))
+}
+
+// Defence is Combat plus Rank plus the bonus of the best armour.
+func (a Adventurer) Defence() int {
+	return a.Ability("Combat") + a.Rank + a.bonus("armour", "")
+}
+
+func (a Adventurer) HasCodeword(word string) bool {
+	return slices.ContainsFunc(a.Codewords, func(c string) bool {
+		return strings.EqualFold(c, word)
+	})
+}
+
+// possession finds an item by name, or returns -1.
+func (a Adventurer) possession(name string) int {
+	return slices.IndexFunc(a.Possessions, func(p Item) bool {
+		return strings.EqualFold(p.Name, name)
+	})
+}
+
+// sheetValues gives the content of the fields of the Adventure Sheet, by SHEET_FIELDS name.
+func (a Adventurer) sheetValues() map[string]string {
+	values := map[string]string{
+		"Name": a.Name,
+		"Profession": a.Profession,
+		"God": a.God,
+		"Rank": strconv.Itoa(a.Rank),
+		"Stamina": fmt.Sprintf("%d/%d", a.Stamina, a.MaxStamina),
+		"Defence": strconv.Itoa(a.Defence()),
+		"Money": fmt.Sprintf("%d Shards", a.Shards),
+		"Resurrection arrangement": a.Resurrection,
+		"Titles and honours": strings.Join(a.Titles, ", "),
+		SHEET_BLESSINGS: strings.Join(a.Blessings, ", "),
+	}
+	for _, ab := range ABILITIES {
+		values[ab] = strconv.Itoa(a.Abilities[ab])
+		if score := a.Ability(ab); score != a.Abilities[ab] {
+			values[ab] += fmt.Sprintf(" (%d)", score)
+		}
+	}
+	return values
+}
+
+// manifestRow lays a ship out like a row of the Ship's Manifest.
+func (s Ship) manifestRow() []string {
+	var capacity string
+	if c, ok := SHIP_CAPACITY[capitalize(s.Type)]; ok {
+		capacity = strconv.Itoa(c)
+	}
+	return []string{capitalize(s.Type), s.Name, capitalize(s.Crew), capacity, strings.Join(s.Cargo, ", "), s.Docked}
+}
+
+// sheetRows lays the adventurer out like the Adventure Sheet.
+func (a Adventurer) sheetRows() (rows [][]string) {
+	values := a.sheetValues()
+	for _, f := range SHEET_FIELDS {
+		rows = append(rows, []string{f, values[f]})
+	}
+	for i := 0; i < SHEET_POSSESSIONS; i++ {
+		var p string
+		if i < len(a.Possessions) {
+			p = equipmentName(a.Possessions[i])
+		}
+		rows = append(rows, []string{fmt.Sprintf("Possession %d", i+1), p})
+	}
+	rows = append(rows, []string{SHEET_BLESSINGS, values[SHEET_BLESSINGS]})
+	rows = append(rows, []string{"Codewords", strings.Join(a.Codewords, ", ")})
+	return
+}
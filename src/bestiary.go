@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// --- BESTIARY ---
+// With -bestiary, the 'Bestiary' report lists the enemies of the books in alphabetical order,
+// with their stats and every section where they are fought. An enemy met in several places
+// with the same stats has a single row. With -combat, the rows also tell the odds of the starting professions.
+
+type beast struct {
+	fight Fight
+	book Book	// Book where the enemy is first met, whose professions the odds are for
+	sections []Section
+}
+
+// beasts gathers the enemies of the books, in alphabetical order.
+func beasts(document Document) (found []beast) {
+	for _, bk := range document.Books {
+		for _, s := range bk.Sections {
+			for _, n := range findNodes(s.Content, NODE_FIGHT) {
+				f := *n.Fight
+				i := slices.IndexFunc(found, func(b beast) bool {
+					return strings.EqualFold(b.fight.Name, f.Name) && b.fight.Combat == f.Combat && b.fight.Defence == f.Defence && b.fight.Stamina == f.Stamina
+				})
+				if i < 0 {
+					found = append(found, beast{fight: f, book: bk})
+					i = len(found) - 1
+				}
+				if !slices.ContainsFunc(found[i].sections, func(other Section) bool { return other.ID == s.ID }) {
+					found[i].sections = append(found[i].sections, s)
+				}
+			}
+		}
+	}
+	slices.SortStableFunc(found, func(a, b beast) int {
+		return strings.Compare(strings.ToLower(a.fight.Name), strings.ToLower(b.fight.Name))
+	})
+	return
+}
+
+// bestiary lists every enemy of the books, with the sections where it is met.
+func bestiary(document Document) (r Report) {
+	r.ID = "bestiary"
+	r.Title = "Bestiary"
+	r.Intro = "Every enemy of the books, in alphabetical order, with the sections where it is fought."
+	var professions []string
+	if *combat {
+		r.Intro = "Every enemy of the books, in alphabetical order, with the sections where it is fought, the chance of each starting profession to win against it and the Stamina it loses when it does."
+		if *weaponBonus > 0 || *armourBonus > 0 {
+			r.Intro += fmt.Sprintf(" The professions have +%d to their weapon and +%d to their armour.", *weaponBonus, *armourBonus)
+		}
+		for _, bk := range document.Books {
+			for _, p := range bk.Professions {
+				if !slices.Contains(professions, p.Name) {
+					professions = append(professions, p.Name)
+				}
+			}
+		}
+	}
+	table := ReportTable{Header: []string{"Enemy", "Combat", "Defence", "Stamina", "Sections"}, Sortable: true}
+	for _, p := range professions {
+		table.Header = append(table.Header, p + " wins", p + " loses")
+	}
+	for _, b := range beasts(document) {
+		var sections ReportCell
+		for _, s := range b.sections {
+			sections.List = append(sections.List, bookSectionCell(s))
+		}
+		row := []ReportCell{{Text: b.fight.Name}, {Text: strconv.Itoa(b.fight.Combat)}, {Text: strconv.Itoa(b.fight.Defence)},
+			{Text: strconv.Itoa(b.fight.Stamina)}, sections}
+		for _, name := range professions {
+			i := slices.IndexFunc(b.book.Professions, func(p Profession) bool { return p.Name == name })
+			if i < 0 || !fightHasOdds(b.book.Professions, b.fight) {
+				row = append(row, ReportCell{}, ReportCell{})
+				continue
+			}
+			o := simulateFight(combatant(b.book.Professions[i]), b.fight)
+			row = append(row, ReportCell{Text: percent(o.win)}, ReportCell{Text: fmt.Sprintf("%.1f", o.loss)})
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	r.Tables = append(r.Tables, table)
+	return
+}
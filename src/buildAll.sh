@@ -1,14 +1,14 @@
 #!/bin/sh
 echo "Building for linux..."
-go build -o ../release/jaflToHtml-linux jaflToHtml.go
+go build -o ../release/jaflToHtml-linux .
 chmod +x ../release/jaflToHtml-linux
 echo "completed"
 echo "Building for windows..."
-GOOS=windows GOARCH=amd64 CGO_ENABLED=0 go build -o ../release/jaflToHtml-win.exe jaflToHtml.go
+GOOS=windows GOARCH=amd64 CGO_ENABLED=0 go build -o ../release/jaflToHtml-win.exe .
 echo "completed"
 echo "Building for mac-amd64..."
-GOOS=darwin GOARCH=amd64 CGO_ENABLED=0 go build -o ../release/jaflToHtml-mac-amd64 jaflToHtml.go
+GOOS=darwin GOARCH=amd64 CGO_ENABLED=0 go build -o ../release/jaflToHtml-mac-amd64 .
 echo "completed"
 echo "Building for mac-arm64..."
-GOOS=darwin GOARCH=arm64 CGO_ENABLED=0 go build -o ../release/jaflToHtml-mac-arm64 jaflToHtml.go
+GOOS=darwin GOARCH=arm64 CGO_ENABLED=0 go build -o ../release/jaflToHtml-mac-arm64 .
 echo "completed"
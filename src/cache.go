@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// --- CACHE ---
+// With -cache, what parse() gets from each file is kept in a directory, under a hash of the file,
+// of the program and of everything that changes how it is converted: the flags, the tag rules,
+// the imported adventurer, the book and its starting professions. The next conversions only parse
+// the files that changed, and take the others from the cache. Files with errors are never kept.
+// Entries are never removed: the directory can be deleted at any time.
+
+// Bump it whenever the entries change
+const CACHE_VERSION = 1
+
+// Flags that do not change how a file is converted
+var CACHE_IGNORED_FLAGS = []string{"cache", "check-reproducible", "format", "jobs", "keep-going", "trade-csv"}
+
+// Hash of the program and of the options, shared by every entry
+var cacheOptions string
+
+// Files taken from the cache, and files parsed with the cache on
+var cacheHits, cacheMisses atomic.Int64
+
+type cacheEntry struct {
+	Output string `json:"output"`
+	Sections []Section `json:"sections,omitempty"`
+	Unknown []cachedTag `json:"unknown,omitempty"`
+}
+
+type cachedTag struct {
+	Name string `json:"name"`
+	Location string `json:"location"`
+}
+
+// initCache makes the cache directory, and hashes the program and the options.
+func initCache() error {
+	if err := os.MkdirAll(*cacheDir, 0755); err != nil {
+		return err
+	}
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d\x00", CACHE_VERSION)
+	// A new build of the program converts the files its own way
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	program, err := os.Open(executable)
+	if err != nil {
+		return err
+	}
+	defer program.Close()
+	if _, err = io.Copy(hash, program); err != nil {
+		return err
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		if !slices.Contains(CACHE_IGNORED_FLAGS, f.Name) {
+			fmt.Fprintf(hash, "%s=%s\x00", f.Name, f.Value)
+		}
+	})
+	encoder := json.NewEncoder(hash)
+	if err = encoder.Encode(tagRules); err != nil {
+		return err
+	}
+	if err = encoder.Encode(hero); err != nil {
+		return err
+	}
+	cacheOptions = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+// cacheScope hashes what replace() reads from the book being converted, for the files about to be parsed.
+func cacheScope() string {
+	if *cacheDir == "" {
+		return ""
+	}
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%d\x00%s\x00", cacheOptions, book, dir)
+	json.NewEncoder(hash).Encode(startingProfessions())
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// parseCached is parse(), that takes what it gets from the cache when the file has not changed.
+func parseCached(filename, scope string) (parsed, error) {
+	if *cacheDir == "" {
+		return parse(filename)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return parse(filename)
+	}
+	hash := sha256.New()
+	io.WriteString(hash, scope)
+	hash.Write(data)
+	entryPath := filepath.Join(*cacheDir, hex.EncodeToString(hash.Sum(nil)) + ".json")
+
+	if raw, err := os.ReadFile(entryPath); err == nil {
+		var entry cacheEntry
+		if json.Unmarshal(raw, &entry) == nil {
+			cacheHits.Add(1)
+			return entry.parsed(filename), nil
+		}
+	}
+	cacheMisses.Add(1)
+	p, err := parse(filename)
+	if err == nil {
+		// A broken cache only costs a parse, so it does not stop the conversion
+		if errStore := storeEntry(entryPath, newCacheEntry(p)); errStore != nil {
+			fmt.Fprintln(os.Stderr, "Could not cache", filename + ":", errStore)
+		}
+	}
+	return p, err
+}
+
+// storeEntry writes an entry under another name first, so that a file parsed by two conversions at once is never read half written.
+func storeEntry(entryPath string, entry cacheEntry) error {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(entryPath), "entry")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(raw)
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), entryPath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func newCacheEntry(p parsed) (entry cacheEntry) {
+	entry.Output = p.output.String()
+	entry.Sections = p.sections
+	for _, t := range p.unknown {
+		entry.Unknown = append(entry.Unknown, cachedTag{t.name, t.location})
+	}
+	return
+}
+
+func (entry cacheEntry) parsed(filename string) (p parsed) {
+	p.filename = filename
+	p.output = new(strings.Builder)
+	p.output.WriteString(entry.Output)
+	p.sections = entry.Sections
+	for _, t := range entry.Unknown {
+		p.unknown = append(p.unknown, tagLocation{t.Name, t.Location})
+	}
+	return
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// --- PRE-GENERATED CHARACTERS ---
+// With -pregenerated, an appendix holds a filled-in Adventure Sheet for every starting adventurer
+// of Adventurers.xml, so that new players can print one and start playing right away.
+
+const PREGENERATED_TITLE = "Pre-generated characters"
+
+const FMT_PREGENERATED =	// list of FMT_PREGENERATED_LINK
+`
+<div class="page">
+	<h1 class="title" id="pregenerated">Pre-generated characters</h1>
+	<ul class="pregenerated">
+%s
+	</ul>
+</div>
+`
+const FMT_PREGENERATED_LINK =	// id, adventurerTitle
+`		<li><a href="#%s">%s</a></li>
+`
+// Used instead of Sheet.html when it is a picture
+const FMT_PREGENERATED_SHEET =	// id, adventurerTitle, rows
+`
+<div class="page sheet" id="%s">
+	<h1>%s</h1>
+	<table class="sheet-table">
+%s
+	</table>
+</div>
+`
+
+// pregeneratedAdventurers lists the starting adventurers of the books, once each.
+func pregeneratedAdventurers(document Document) (adventurers []Adventurer) {
+	if !*pregenerated {
+		return
+	}
+	seen := make(map[string]bool)
+	for _, bk := range document.Books {
+		for _, p := range bk.Professions {
+			a := newAdventurer(p)
+			if !seen[adventurerID(a)] {
+				seen[adventurerID(a)] = true
+				adventurers = append(adventurers, a)
+			}
+		}
+	}
+	return
+}
+
+func adventurerID(a Adventurer) string {
+	return "sheet-" + linkify(a.Name + " " + a.Profession)
+}
+
+func adventurerTitle(a Adventurer) string {
+	return a.Name + ", " + a.Profession
+}
+
+// pregeneratedPages fills in a copy of the Adventure Sheet for every starting adventurer.
+func pregeneratedPages(document Document, sheet string) (out string) {
+	adventurers := pregeneratedAdventurers(document)
+	if len(adventurers) == 0 {
+		return
+	}
+	var links string
+	for _, a := range adventurers {
+		links += fmt.Sprintf(FMT_PREGENERATED_LINK, adventurerID(a), html.EscapeString(adventurerTitle(a)))
+	}
+	out = fmt.Sprintf(FMT_PREGENERATED, strings.TrimSuffix(links, "\n"))
+	for i := range adventurers {
+		out += sheetPage(sheet, &adventurers[i])
+	}
+	return
+}
+
+// sheetPage is a copy of the Adventure Sheet filled in with an adventurer, titled with its name.
+func sheetPage(sheet string, a *Adventurer) string {
+	if !isHTMLSheet(sheet) {
+		var rows string
+		for _, r := range a.sheetRows() {
+			rows += "\t\t<tr><th>" + html.EscapeString(r[0]) + "</th><td class=\"field\">" + html.EscapeString(r[1]) + "</td></tr>\n"
+		}
+		return fmt.Sprintf(FMT_PREGENERATED_SHEET, adventurerID(*a), html.EscapeString(adventurerTitle(*a)), strings.TrimSuffix(rows, "\n"))
+	}
+	page := fillSheetWith(sheet, a)
+	page = strings.Replace(page, `id="sheet"`, `id="` + adventurerID(*a) + `"`, 1)
+	page = strings.Replace(page, "<h1>Adventure Sheet</h1>", "<h1>" + html.EscapeString(adventurerTitle(*a)) + "</h1>", 1)
+	return page
+}
+
+// --- NEW CHARACTER ---
+// The 'newchar' command picks a starting adventurer at random, as the first roll of a new game.
+// The pick only depends on the seed, which is printed, so that anyone can check it
+// by running the command again with the same seed and books.
+
+// Seeds picked by newchar stay short enough to be shared
+const NEWCHAR_SEEDS = 1000000
+
+const FMT_NEWCHAR =	// adventurerTitle, seed, printStats, book, section id, FMT_TURNTO, sheet
+`
+<div class="page">
+	<h1 class="title">%s</h1>
+	<p>Seed: %d</p>
+	%s
+	<p>Start: <a href="%s#%s">%s</a></p>
+</div>
+%s
+`
+
+func newchar(args []string) {
+	flags := flag.NewFlagSet(COMMAND_NEWCHAR, flag.ExitOnError)
+	seed := flags.Uint64("seed", 0, "Seed of the adventurer (random if not given)")
+	start := flags.Int("b", 1, "Number of the book to start in")
+	profession := flags.String("profession", "", "Profession of the adventurer (picked with the seed if not given)")
+	charFormat := flags.String("format", FORMAT_HTML, "Output format: html or json")
+	bookFile := flags.String("book", DEFAULT_OUTPUT, "Converted book that the start section links to (html format only)")
+	flags.Parse(args)
+
+	switch *charFormat {
+		case FORMAT_HTML, FORMAT_JSON:
+		default:
+			usageError(flags, fmt.Errorf("Unknown output format %q", *charFormat))
+	}
+	seedSet := false
+	flags.Visit(func(f *flag.Flag) {
+		seedSet = seedSet || f.Name == "seed"
+	})
+	if !seedSet {
+		*seed = rand.Uint64N(NEWCHAR_SEEDS)
+	}
+
+	root = flags.Arg(0)
+	if root == "" {
+		root = DEFAULT_DIR
+	}
+	output = flags.Arg(1)
+	if output == "" {
+		output = "adventurer." + *charFormat
+	}
+
+	// Only the starting book is needed
+	progress = io.Discard
+	books := listBooks()
+	if *start < 1 || *start > len(books) {
+		check(fmt.Errorf("Book %d not found", *start))
+	}
+	bk := loadBook(*start, stripExt(books[*start-1]), io.Discard)
+	progress = os.Stdout
+
+	a, ok := rollAdventurer(bk, *seed, *profession)
+	if !ok {
+		check(fmt.Errorf("Found no profession called %s in %s", *profession, bk.Title))
+	}
+	fmt.Printf("%s (seed %d)\n", adventurerTitle(a), *seed)
+
+	if *charFormat == FORMAT_JSON {
+		check(writeJSON(output, a))
+	} else {
+		var sheet string
+		if _, err := os.Stat(SHEET_NAME); err == nil {
+			sheet = load(SHEET_NAME)
+		}
+		var name string
+		for _, s := range bk.Sections {
+			if s.ID == a.Section {
+				name = s.Name
+			}
+		}
+		content := HEAD + fmt.Sprintf(FMT_NEWCHAR, html.EscapeString(adventurerTitle(a)), *seed, printStats(a.Profession),
+			filepath.ToSlash(*bookFile), a.Section, fmt.Sprintf(FMT_TURNTO, html.EscapeString(name)), sheetPage(sheet, &a))
+		check(os.WriteFile(output, []byte(content), 0644))
+	}
+	fmt.Println("Saved in", output)
+}
+
+// rollAdventurer picks a profession of a book with a seed, unless one is asked for,
+// and starts the adventurer in the section of that profession.
+func rollAdventurer(bk Book, seed uint64, profession string) (a Adventurer, ok bool) {
+	if len(bk.Professions) == 0 {
+		return
+	}
+	p := bk.Professions[rand.New(rand.NewPCG(seed, seed)).IntN(len(bk.Professions))]
+	if profession != "" {
+		i := slices.IndexFunc(bk.Professions, func(p Profession) bool { return strings.EqualFold(p.Name, profession) })
+		if i < 0 {
+			return
+		}
+		p = bk.Professions[i]
+	}
+	a = newAdventurer(p)
+	a.Seed = seed
+	a.Section = startingSection(&bk, p.Name)
+	return a, true
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// --- COMBAT ODDS ---
+// With -combat, every fight tells how likely each starting profession is to win it,
+// and the 'Bestiary' report gets their odds against every enemy, in a table that can be sorted.
+// The odds follow the combat rules of the books: each round, the adventurer rolls two dice
+// and adds their Combat, and the enemy loses the amount by which the total beats its Defence.
+// Then, if it is still standing, the enemy does the same against the adventurer's Defence.
+// They are computed exactly, rather than by playing the fights over and over.
+
+const TXT_FIGHT_ODDS = "%s wins %s of the time, losing %.1f Stamina on average"
+
+type fightOdds struct {
+	win float64
+	loss float64	// Stamina lost, on average, in the fights that are won
+}
+
+// damageChances gives the chance of each amount of damage dealt by an attack.
+func damageChances(combat, defence int) (chances []float64) {
+	for i := 1; i <= 6; i++ {
+		for j := 1; j <= 6; j++ {
+			damage := max(i + j + combat - defence, 0)
+			for len(chances) <= damage {
+				chances = append(chances, 0)
+			}
+			chances[damage] += 1.0 / 36
+		}
+	}
+	return
+}
+
+// simulateFight works out the chance of winning a fight, and the Stamina it costs.
+func simulateFight(a Adventurer, f Fight) fightOdds {
+	attack := damageChances(a.Ability("Combat"), f.Defence)
+	defence := damageChances(f.Combat, a.Defence())
+	stamina := max(a.Stamina, 1)
+
+	// win[h][e] is the chance of winning with h Stamina left against an enemy with e,
+	// and left[h][e] the Stamina expected to be left at the end, counted only in the fights that are won.
+	win := make([][]float64, stamina + 1)
+	left := make([][]float64, stamina + 1)
+	for h := range win {
+		win[h] = make([]float64, f.Stamina + 1)
+		left[h] = make([]float64, f.Stamina + 1)
+	}
+	for h := 1; h <= stamina; h++ {
+		for e := 1; e <= f.Stamina; e++ {
+			// Rounds where nobody is hurt start the same fight again
+			stall := attack[0] * defence[0]
+			if stall >= 1 {
+				continue
+			}
+			var w, l float64
+			for d1, p1 := range attack {
+				if d1 >= e {
+					w += p1
+					l += p1 * float64(h)
+					continue
+				}
+				for d2, p2 := range defence {
+					if (d1 == 0 && d2 == 0) || d2 >= h {
+						continue
+					}
+					w += p1 * p2 * win[h-d2][e-d1]
+					l += p1 * p2 * left[h-d2][e-d1]
+				}
+			}
+			win[h][e] = w / (1 - stall)
+			left[h][e] = l / (1 - stall)
+		}
+	}
+
+	odds := fightOdds{win: win[stamina][f.Stamina]}
+	if odds.win > 0 {
+		odds.loss = float64(stamina) - left[stamina][f.Stamina] / odds.win
+	}
+	return odds
+}
+
+// fightHasOdds tells whether a fight can be given odds: without the Stamina of the enemy, there is no telling how long it lasts,
+// and outside of the books, there are no starting professions to fight it.
+func fightHasOdds(professions []Profession, f Fight) bool {
+	return f.Stamina > 0 && len(professions) > 0
+}
+
+// combatant is a starting adventurer, with the bonuses asked for on the command line.
+func combatant(p Profession) Adventurer {
+	a := newAdventurer(p)
+	if *weaponBonus > 0 {
+		a.Possessions = append(a.Possessions, Item{Name: "weapon", Type: "weapon", Bonus: strconv.Itoa(a.bonus("weapon", "") + *weaponBonus)})
+	}
+	if *armourBonus > 0 {
+		a.Possessions = append(a.Possessions, Item{Name: "armour", Type: "armour", Bonus: strconv.Itoa(a.bonus("armour", "") + *armourBonus)})
+	}
+	return a
+}
+
+// fightOddsText is the annotation of a fight, with the odds of every starting profession of its book.
+func fightOddsText(professions []Profession, f Fight) string {
+	var odds []string
+	for _, p := range professions {
+		o := simulateFight(combatant(p), f)
+		odds = append(odds, fmt.Sprintf(TXT_FIGHT_ODDS, p.Name, percent(o.win), o.loss))
+	}
+	return strings.Join(odds, "; ")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSimulateFight(t *testing.T) {
+	fighter := func(combat, rank, stamina int) Adventurer {
+		return Adventurer{Rank: rank, Stamina: stamina, Abilities: map[string]int{"Combat": combat}}
+	}
+	for _, c := range []struct {
+		name string
+		a Adventurer
+		f Fight
+		win, loss float64
+	}{
+		// The first blow always kills the enemy, which never strikes back
+		{"one-sided win", fighter(20, 0, 10), Fight{Combat: 0, Defence: 0, Stamina: 5}, 1, 0},
+		// The adventurer can never get through the enemy's Defence
+		{"one-sided loss", fighter(0, 0, 10), Fight{Combat: 20, Defence: 20, Stamina: 5}, 0, 0},
+		// Nobody can hurt anybody
+		{"stalemate", fighter(0, 20, 10), Fight{Combat: 0, Defence: 20, Stamina: 5}, 0, 0},
+		// Both hit with a chance p of 15/36 and kill with one blow, the adventurer first:
+		// the adventurer wins with p / (1 - (1-p)²) = 1 / (2-p), and is never hurt when winning
+		{"symmetric", fighter(0, 7, 1), Fight{Combat: 0, Defence: 7, Stamina: 1}, 36.0 / 57, 0},
+	} {
+		o := simulateFight(c.a, c.f)
+		if math.Abs(o.win - c.win) > 1e-9 || math.Abs(o.loss - c.loss) > 1e-9 {
+			t.Errorf("%s: simulateFight = %+v, want win %v, loss %v", c.name, o, c.win, c.loss)
+		}
+	}
+}
+
+func TestSimulateFightFirstBlow(t *testing.T) {
+	// With the same scores, striking first is an advantage
+	a := Adventurer{Rank: 3, Stamina: 8, Abilities: map[string]int{"Combat": 4}}
+	o := simulateFight(a, Fight{Combat: 4, Defence: 7, Stamina: 8})
+	if o.win <= 0.5 || o.win >= 1 {
+		t.Errorf("simulateFight of equal fighters = %v, want between 0.5 and 1", o.win)
+	}
+	if o.loss <= 0 || o.loss >= 8 {
+		t.Errorf("Stamina lost by equal fighters = %v, want between 0 and 8", o.loss)
+	}
+}
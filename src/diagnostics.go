@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// --- DIAGNOSTICS ---
+// A malformed XML file stops the conversion, unless -keep-going is passed.
+// The error is then recorded with the file and the section it was found in, the section
+// is replaced by a placeholder (so that the links to it still work), and the conversion
+// carries on with the other files. The errors are listed at the end of the conversion.
+
+// Exit codes, documented in the README: keep them as they are
+const (
+	EXIT_OK = 0
+	EXIT_FAILURE = 1	// Something went wrong that the conversion cannot carry on after
+	EXIT_USAGE = 2	// Unknown flag, or missing argument of a command
+	EXIT_PARSE_ERRORS = 3	// -keep-going: the output was saved, but some files could not be parsed
+	EXIT_NOT_REPRODUCIBLE = 4	// -check-reproducible: the two runs differ
+)
+
+const FMT_BROKEN_SECTION =	// file, line
+`<p class="broken">This section could not be converted because of an error in %s, line %d.</p>`
+const TXT_BROKEN_SECTION =	// file, line
+`This section could not be converted because of an error in %s, line %d.`
+
+// ParseError is an error of parse(), with the position in the file where it was found.
+type ParseError struct {
+	File string
+	Line int
+	Message string
+	Position string	// POSITION, with the state of the parser
+	Section *element	// Section that was being parsed, if any
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %s %s", e.File, e.Message, e.Position)
+}
+
+// Errors recorded with -keep-going
+var diagnostics []*ParseError
+
+// parseError builds the error of parse() at the current position.
+func parseError(filename string, line int, message, position string, s stack) *ParseError {
+	e := &ParseError{File: filename, Line: line, Message: message, Position: position}
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i].Name == SECTION {
+			section := s[i]
+			e.Section = &section
+			break
+		}
+	}
+	return e
+}
+
+// skipBroken records the error of parse(), and puts a placeholder in place of the broken section with -keep-going.
+func skipBroken(p parsed, err error) parsed {
+	if err == nil {
+		return p
+	}
+	pe, ok := err.(*ParseError)
+	if !*keepGoing || !ok {
+		check(err)
+	}
+	diagnostics = append(diagnostics, pe)
+	if pe.Section != nil {
+		file := filepath.Base(pe.File)
+		placeholder := element{Name: SECTION, Attributes: pe.Section.Attributes, Content: fmt.Sprintf(FMT_BROKEN_SECTION, file, pe.Line)}
+		p.output.WriteString(replace(placeholder))
+		text := Node{Type: NODE_TEXT, Text: fmt.Sprintf(TXT_BROKEN_SECTION, file, pe.Line)}
+		p.sections = append(p.sections, newSection(Node{Attributes: pe.Section.Attributes, Children: []Node{{Type: NODE_PARAGRAPH, Children: []Node{text}}}}))
+	}
+	return p
+}
+
+// printDiagnostics lists the errors recorded with -keep-going.
+func printDiagnostics(w io.Writer) {
+	if len(diagnostics) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n%d file(s) could not be parsed. They were converted up to the error, and the section it is in was replaced by a placeholder:\n", len(diagnostics))
+	for _, e := range diagnostics {
+		where := fmt.Sprintf("%s, line %d", e.File, e.Line)
+		if e.Section != nil {
+			where += ", section " + e.Section.Attributes["name"]
+		}
+		fmt.Fprintf(w, "\n%s\n%s\n%s\n", where, e.Message, e.Position)
+	}
+}
+
+// usageError prints a wrong flag or argument with the usage, and stops.
+func usageError(flags *flag.FlagSet, err error) {
+	fmt.Fprintln(flags.Output(), err)
+	flags.Usage()
+	os.Exit(EXIT_USAGE)
+}
+
+// exitWithDiagnostics tells with the exit code whether errors were recorded with -keep-going.
+func exitWithDiagnostics() {
+	if len(diagnostics) > 0 {
+		os.Exit(EXIT_PARSE_ERRORS)
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// --- DIFF ---
+// The 'diff' command compares two versions of the JAFL books, parsed into the section model,
+// and lists what changed in every section: its links, fights, prices, codewords and text.
+// It is meant for the errata sheets of printed editions, when JAFL updates its XML.
+
+// Words kept around the changes of the text
+const DIFF_CONTEXT = 6
+
+// Folders and archives of the books, named after their number
+var bookNamePattern = regexp.MustCompile(`(?i)^book(\d+)$`)
+
+const FMT_DIFF =	// old root, new root, books
+`
+<div class="page diff">
+	<h1 class="title">Errata</h1>
+	<p>From %s to %s</p>
+%s
+</div>
+`
+const FMT_DIFF_BOOK =	// title, content
+`	<h2>%s</h2>
+%s`
+const FMT_DIFF_SECTION =	// name, content
+`	<h3>Section %s</h3>
+	<ul>
+%s	</ul>
+`
+
+type BookDiff struct {
+	Book int `json:"book"`
+	Title string `json:"title"`
+	Added []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []SectionDiff `json:"changed,omitempty"`
+}
+
+type SectionDiff struct {
+	ID string `json:"id"`
+	Name string `json:"name"`
+	Links *ListDiff `json:"links,omitempty"`
+	Fights *ListDiff `json:"fights,omitempty"`
+	Prices *ListDiff `json:"prices,omitempty"`
+	Codewords *ListDiff `json:"codewords,omitempty"`
+	Text []TextEdit `json:"text,omitempty"`
+}
+
+type ListDiff struct {
+	Removed []string `json:"removed,omitempty"`
+	Added []string `json:"added,omitempty"`
+}
+
+// A run of words of the text, kept (=), removed (-) or added (+)
+type TextEdit struct {
+	Op string `json:"op"`
+	Text string `json:"text"`
+}
+
+func diff(args []string) {
+	flags := flag.NewFlagSet(COMMAND_DIFF, flag.ExitOnError)
+	diffFormat := flags.String("format", FORMAT_TEXT, "Output format: txt, html or json")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: jaflToHtml diff [flags] <old directory> <new directory> [output file]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(EXIT_USAGE)
+	}
+	switch *diffFormat {
+		case FORMAT_TEXT, FORMAT_HTML, FORMAT_JSON:
+		default:
+			usageError(flags, fmt.Errorf("Unknown output format %q", *diffFormat))
+	}
+
+	progress = io.Discard
+	oldBooks := loadVersion(flags.Arg(0))
+	newBooks := loadVersion(flags.Arg(1))
+	diffs := diffBooks(oldBooks, newBooks)
+
+	out := os.Stdout
+	if flags.Arg(2) != "" {
+		file, err := os.Create(flags.Arg(2))
+		check(err)
+		defer file.Close()
+		out = file
+	}
+	switch *diffFormat {
+		case FORMAT_JSON:
+			encoder := json.NewEncoder(out)
+			encoder.SetIndent("", "\t")
+			check(encoder.Encode(diffs))
+		case FORMAT_HTML:
+			fmt.Fprint(out, HEAD + fmt.Sprintf(FMT_DIFF, html.EscapeString(flags.Arg(0)), html.EscapeString(flags.Arg(1)), htmlDiff(diffs)))
+		default:
+			fmt.Fprint(out, textDiff(diffs))
+	}
+}
+
+// loadVersion parses the books of a directory, from their folders or else from their archives, like listBooks().
+// Books that are not extracted in it yet are extracted in a temporary directory, so that both versions do not mix.
+// A book is numbered from its name rather than its place, so that a missing book does not shift the next ones.
+func loadVersion(r string) (books []Book) {
+	readDir, err := os.ReadDir(r)
+	check(err)
+	names := map[int]string{}
+	var numbers []int
+	for _, f := range readDir {
+		name := f.Name()
+		if filepath.Ext(name) == ZIP_EXT {
+			name = stripExt(name)
+		} else if !f.IsDir() {
+			continue
+		}
+		m := bookNamePattern.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		n := atoi(m[1])
+		if _, ok := names[n]; !ok {
+			numbers = append(numbers, n)
+		}
+		names[n] = name
+	}
+	slices.Sort(numbers)
+	tmp, err := os.MkdirTemp("", "jafl-diff")
+	check(err)
+	defer os.RemoveAll(tmp)
+	for _, n := range numbers {
+		d := filepath.Join(r, names[n])
+		if !existDir(d) {
+			d = filepath.Join(tmp, names[n])
+			extractBook(filepath.Join(r, names[n] + ZIP_EXT), d)
+		}
+		books = append(books, loadBook(n, d, io.Discard))
+	}
+	return
+}
+
+func diffBooks(oldBooks, newBooks []Book) (diffs []BookDiff) {
+	for _, nb := range newBooks {
+		d := BookDiff{Book: nb.Number, Title: nb.Title}
+		i := slices.IndexFunc(oldBooks, func(b Book) bool { return b.Number == nb.Number })
+		if i < 0 {
+			for _, s := range nb.Sections {
+				d.Added = append(d.Added, s.Name)
+			}
+			diffs = append(diffs, d)
+			continue
+		}
+		ob := oldBooks[i]
+		for _, s := range ob.Sections {
+			if !slices.ContainsFunc(nb.Sections, func(other Section) bool { return other.ID == s.ID }) {
+				d.Removed = append(d.Removed, s.Name)
+			}
+		}
+		for _, s := range nb.Sections {
+			j := slices.IndexFunc(ob.Sections, func(other Section) bool { return other.ID == s.ID })
+			if j < 0 {
+				d.Added = append(d.Added, s.Name)
+				continue
+			}
+			if sd, changed := diffSection(ob.Sections[j], s); changed {
+				d.Changed = append(d.Changed, sd)
+			}
+		}
+		if len(d.Added) > 0 || len(d.Removed) > 0 || len(d.Changed) > 0 {
+			diffs = append(diffs, d)
+		}
+	}
+	for _, ob := range oldBooks {
+		if !slices.ContainsFunc(newBooks, func(b Book) bool { return b.Number == ob.Number }) {
+			d := BookDiff{Book: ob.Number, Title: ob.Title}
+			for _, s := range ob.Sections {
+				d.Removed = append(d.Removed, s.Name)
+			}
+			diffs = append(diffs, d)
+		}
+	}
+	return
+}
+
+func diffSection(o, n Section) (d SectionDiff, changed bool) {
+	d.ID, d.Name = n.ID, n.Name
+	d.Links = diffLists(sectionLinks(o), sectionLinks(n))
+	d.Fights = diffLists(sectionFights(o), sectionFights(n))
+	d.Prices = diffLists(sectionPrices(o), sectionPrices(n))
+	d.Codewords = diffLists(sectionCodewords(o), sectionCodewords(n))
+	oldText, newText := sectionWords(o), sectionWords(n)
+	if !slices.Equal(oldText, newText) {
+		d.Text = diffWords(oldText, newText)
+	}
+	changed = d.Links != nil || d.Fights != nil || d.Prices != nil || d.Codewords != nil || d.Text != nil
+	return
+}
+
+// sectionWords is the text of a section, word by word, without gluing its paragraphs together.
+func sectionWords(s Section) (words []string) {
+	for _, n := range s.Content {
+		words = append(words, strings.Fields(plainText([]Node{n}))...)
+	}
+	return
+}
+
+// diffLists tells which entries are only in one of the lists, counting the repeated ones.
+func diffLists(o, n []string) *ListDiff {
+	var d ListDiff
+	left := slices.Clone(n)
+	for _, s := range o {
+		if i := slices.Index(left, s); i >= 0 {
+			left = slices.Delete(left, i, i+1)
+		} else {
+			d.Removed = append(d.Removed, s)
+		}
+	}
+	d.Added = left
+	if len(d.Removed) == 0 && len(d.Added) == 0 {
+		return nil
+	}
+	return &d
+}
+
+func sectionLinks(s Section) (links []string) {
+	for _, n := range allNodes(s.Content) {
+		if n.Target != nil && n.Type != NODE_GROUP {
+			links = append(links, joinWords(rowLabel(n), plainText(n.Children), "→", targetLabel(n)))
+		}
+	}
+	return
+}
+
+func sectionFights(s Section) (fights []string) {
+	for _, n := range findNodes(s.Content, NODE_FIGHT) {
+		f := n.Fight
+		fights = append(fights, fmt.Sprintf("%s (Combat %d, Defence %d, Stamina %d)", f.Name, f.Combat, f.Defence, f.Stamina))
+	}
+	return
+}
+
+func sectionPrices(s Section) (prices []string) {
+	for _, n := range findNodes(s.Content, NODE_ITEM) {
+		if n.Price != nil {
+			prices = append(prices, fmt.Sprintf("%s: buy %s, sell %s", n.Item.Name, n.Price.Buy, n.Price.Sell))
+		}
+	}
+	return
+}
+
+func sectionCodewords(s Section) (codewords []string) {
+	for _, n := range allNodes(s.Content) {
+		switch {
+			case n.Type == NODE_TICK && n.Codeword != "":
+				codewords = append(codewords, "Tick " + n.Codeword)
+			case n.Type == NODE_CONDITION && n.Attributes["codeword"] != "":
+				codewords = append(codewords, "If " + n.Attributes["codeword"])
+		}
+	}
+	return
+}
+
+// diffWords finds the words removed and added between two texts, through their longest common subsequence.
+func diffWords(o, n []string) (edits []TextEdit) {
+	lcs := make([][]int, len(o) + 1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(n) + 1)
+	}
+	for i := len(o) - 1; i >= 0; i-- {
+		for j := len(n) - 1; j >= 0; j-- {
+			if o[i] == n[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	add := func(op, word string) {
+		if len(edits) > 0 && edits[len(edits)-1].Op == op {
+			edits[len(edits)-1].Text += " " + word
+		} else {
+			edits = append(edits, TextEdit{Op: op, Text: word})
+		}
+	}
+	i, j := 0, 0
+	for i < len(o) || j < len(n) {
+		switch {
+			case i < len(o) && j < len(n) && o[i] == n[j]:
+				add("=", o[i])
+				i, j = i+1, j+1
+			case i < len(o) && (j == len(n) || lcs[i+1][j] >= lcs[i][j+1]):
+				add("-", o[i])
+				i++
+			default:
+				add("+", n[j])
+				j++
+		}
+	}
+	return
+}
+
+// diffContext shortens the kept text between changes to a few words on each side.
+func diffContext(edits []TextEdit, i int) string {
+	words := strings.Fields(edits[i].Text)
+	if len(words) <= 2 * DIFF_CONTEXT {
+		return edits[i].Text
+	}
+	var kept []string
+	if i > 0 {
+		kept = append(kept, words[:DIFF_CONTEXT]...)
+	}
+	kept = append(kept, "…")
+	if i < len(edits) - 1 {
+		kept = append(kept, words[len(words) - DIFF_CONTEXT:]...)
+	}
+	return strings.Join(kept, " ")
+}
+
+func textDiff(diffs []BookDiff) (out string) {
+	if len(diffs) == 0 {
+		return "No changes.\n"
+	}
+	list := func(label string, d *ListDiff) {
+		if d == nil {
+			return
+		}
+		out += "    " + label + ":\n"
+		for _, s := range d.Removed {
+			out += "      - " + s + "\n"
+		}
+		for _, s := range d.Added {
+			out += "      + " + s + "\n"
+		}
+	}
+	for _, d := range diffs {
+		out += fmt.Sprintf("Book %d: %s\n", d.Book, d.Title)
+		if len(d.Added) > 0 {
+			out += "  Added sections: " + strings.Join(d.Added, ", ") + "\n"
+		}
+		if len(d.Removed) > 0 {
+			out += "  Removed sections: " + strings.Join(d.Removed, ", ") + "\n"
+		}
+		for _, s := range d.Changed {
+			out += "  Section " + s.Name + ":\n"
+			list("Links", s.Links)
+			list("Fights", s.Fights)
+			list("Prices", s.Prices)
+			list("Codewords", s.Codewords)
+			if s.Text != nil {
+				var words []string
+				for i, e := range s.Text {
+					switch e.Op {
+						case "-":
+							words = append(words, "[-" + e.Text + "-]")
+						case "+":
+							words = append(words, "{+" + e.Text + "+}")
+						default:
+							words = append(words, diffContext(s.Text, i))
+					}
+				}
+				out += "    Text: " + strings.Join(words, " ") + "\n"
+			}
+		}
+		out += "\n"
+	}
+	return
+}
+
+func htmlDiff(diffs []BookDiff) (out string) {
+	if len(diffs) == 0 {
+		return "\t<p>No changes.</p>\n"
+	}
+	list := func(label string, d *ListDiff) (items string) {
+		if d == nil {
+			return
+		}
+		for _, s := range d.Removed {
+			items += "\t\t<li>" + label + ": <del>" + html.EscapeString(s) + "</del></li>\n"
+		}
+		for _, s := range d.Added {
+			items += "\t\t<li>" + label + ": <ins>" + html.EscapeString(s) + "</ins></li>\n"
+		}
+		return
+	}
+	for _, d := range diffs {
+		var content string
+		if len(d.Added) > 0 {
+			content += "\t<p>Added sections: <ins>" + html.EscapeString(strings.Join(d.Added, ", ")) + "</ins></p>\n"
+		}
+		if len(d.Removed) > 0 {
+			content += "\t<p>Removed sections: <del>" + html.EscapeString(strings.Join(d.Removed, ", ")) + "</del></p>\n"
+		}
+		for _, s := range d.Changed {
+			items := list("Link", s.Links) + list("Fight", s.Fights) + list("Price", s.Prices) + list("Codeword", s.Codewords)
+			if s.Text != nil {
+				var words []string
+				for i, e := range s.Text {
+					switch e.Op {
+						case "-":
+							words = append(words, "<del>" + html.EscapeString(e.Text) + "</del>")
+						case "+":
+							words = append(words, "<ins>" + html.EscapeString(e.Text) + "</ins>")
+						default:
+							words = append(words, html.EscapeString(diffContext(s.Text, i)))
+					}
+				}
+				items += "\t\t<li>Text: " + strings.Join(words, " ") + "</li>\n"
+			}
+			content += fmt.Sprintf(FMT_DIFF_SECTION, html.EscapeString(s.Name), items)
+		}
+		out += fmt.Sprintf(FMT_DIFF_BOOK, html.EscapeString(fmt.Sprintf("Book %d: %s", d.Book, d.Title)), content)
+	}
+	return
+}
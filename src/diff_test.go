@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffWords(t *testing.T) {
+	for _, c := range []struct {
+		name, o, n string
+		want []TextEdit
+	}{
+		{"insertion", "a b c", "a x b c", []TextEdit{{"=", "a"}, {"+", "x"}, {"=", "b c"}}},
+		{"deletion", "a b c", "a c", []TextEdit{{"=", "a"}, {"-", "b"}, {"=", "c"}}},
+		{"replacement", "a b c", "a x y c", []TextEdit{{"=", "a"}, {"-", "b"}, {"+", "x y"}, {"=", "c"}}},
+		{"at the end", "a b", "a b c", []TextEdit{{"=", "a b"}, {"+", "c"}}},
+		{"from nothing", "", "a b", []TextEdit{{"+", "a b"}}},
+	} {
+		if got := diffWords(strings.Fields(c.o), strings.Fields(c.n)); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: diffWords = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDiffLists(t *testing.T) {
+	if d := diffLists([]string{"a", "b"}, []string{"b", "a"}); d != nil {
+		t.Errorf("diffLists of the same entries = %+v, want nil", *d)
+	}
+	// Repeated entries are counted
+	d := diffLists([]string{"a", "a", "b"}, []string{"a", "c"})
+	if d == nil || !reflect.DeepEqual(d.Removed, []string{"a", "b"}) || !reflect.DeepEqual(d.Added, []string{"c"}) {
+		t.Errorf("diffLists = %+v, want removed [a b], added [c]", d)
+	}
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --- FICTIONBOOK 2 ---
+// FB2 is rendered from the book model, since it cannot hold the HTML that replace() produces.
+// Paragraphs in FB2 cannot contain tables, so inline content is buffered in a pending paragraph
+// that gets closed whenever a table or an image comes along.
+
+const FB2_HEAD =	// book title, document id, book title
+`<?xml version="1.0" encoding="UTF-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+<description>
+<title-info>
+<genre>adventure</genre>
+<author><first-name>Dave</first-name><last-name>Morris</last-name></author>
+<author><first-name>Jamie</first-name><last-name>Thomson</last-name></author>
+<book-title>%s</book-title>
+<lang>en</lang>
+</title-info>
+<document-info>
+<author><nickname>jafl-to-html</nickname></author>
+<program-used>jafl-to-html</program-used>
+<date></date>
+<id>jafl-to-html-%s</id>
+<version>1.0</version>
+</document-info>
+</description>
+<body>
+<title><p>%s</p></title>
+`
+
+const FB2_TAIL =
+`</FictionBook>
+`
+
+const FB2_SECTION_OPEN =	// id, title
+`<section id="%s">
+<title><p>%s</p></title>
+`
+
+const FB2_SECTION_CLOSE =
+`</section>
+`
+
+const FB2_LINK =
+`<a l:href="#%s">%s</a>`
+
+const FB2_IMAGE =
+`<image l:href="#%s"/>
+`
+
+const FB2_BINARY =	// id, content type, data
+`<binary id="%s" content-type="%s">%s</binary>
+`
+
+const FB2_FIGHT =	// name, combat, defence, stamina
+`<table>
+<tr><th colspan="3">%s</th></tr>
+<tr><td>Combat: %d</td><td>Defence: %d</td><td>Stamina: %d</td></tr>
+</table>
+`
+
+const FB2_SHOPHEADER =
+`<tr><th>Item</th><th>Buy Price</th><th>Sell Price</th></tr>
+`
+
+type fb2Writer struct {
+	out strings.Builder
+	para string
+	binaries strings.Builder
+	embedded map[string]bool
+	book *Book
+}
+
+func writeFB2(filename string, document Document) error {
+	w := fb2Writer{embedded: make(map[string]bool)}
+
+	bookName := "Fabled Lands"
+	if *b != 0 {
+		bookName = bookTitle(*b)
+	}
+	fmt.Fprintf(&w.out, FB2_HEAD, escapeXML(bookName), linkify(bookName), escapeXML(bookName))
+
+	for _, s := range document.Rules {
+		w.section(s)
+	}
+	fmt.Fprintf(&w.out, FB2_SECTION_OPEN, "world-map", "World Map")
+	w.image(WORLDMAP_NAME, "map-world")
+	w.out.WriteString(FB2_SECTION_CLOSE)
+
+	for i := range document.Books {
+		w.book = &document.Books[i]
+		fmt.Fprintf(&w.out, FB2_SECTION_OPEN, fmt.Sprintf("book-%d", w.book.Number), escapeXML(w.book.Title))
+		w.image(w.book.Map, "map-" + linkify(w.book.Region))
+		for _, s := range w.book.Sections {
+			w.section(s)
+		}
+		w.out.WriteString(FB2_SECTION_CLOSE)
+	}
+	w.book = nil
+
+	w.appendices(document)
+
+	w.out.WriteString("</body>\n")
+	w.out.WriteString(w.binaries.String())
+	w.out.WriteString(FB2_TAIL)
+	return os.WriteFile(filename, []byte(w.out.String()), 0644)
+}
+
+func (w *fb2Writer) section(s Section) {
+	name := escapeXML(s.Name)
+	name += sectionBoxes(s.ID, s.Boxes)
+	fmt.Fprintf(&w.out, FB2_SECTION_OPEN, fb2ID(s.ID), name)
+	if s.Profession != "" && w.book != nil {
+		for _, p := range w.book.Professions {
+			if p.Name == s.Profession {
+				w.block(fb2Profession(p))
+			}
+		}
+	}
+	w.nodes(s.Content)
+	w.flush()
+	w.out.WriteString(FB2_SECTION_CLOSE)
+}
+
+// inline adds content to the pending paragraph.
+func (w *fb2Writer) inline(s string) {
+	w.para += s
+}
+
+// flush closes the pending paragraph, if there is one.
+func (w *fb2Writer) flush() {
+	if p := strings.Join(strings.Fields(w.para), " "); p != "" {
+		w.out.WriteString("<p>" + p + "</p>\n")
+	}
+	w.para = ""
+}
+
+// block adds content that cannot live inside a paragraph.
+func (w *fb2Writer) block(s string) {
+	w.flush()
+	w.out.WriteString(s)
+}
+
+// image embeds an image file as a binary and shows it.
+func (w *fb2Writer) image(path, id string) {
+	id = fb2ID(id)
+	if !w.embedded[id] {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Println("Could not embed image:", err)
+			return
+		}
+		fmt.Fprintf(&w.binaries, FB2_BINARY, id, contentType(path), base64.StdEncoding.EncodeToString(data))
+		w.embedded[id] = true
+	}
+	w.block(fmt.Sprintf(FB2_IMAGE, id))
+}
+
+func (w *fb2Writer) nodes(nodes []Node) {
+	for _, n := range nodes {
+		w.node(n)
+	}
+}
+
+func (w *fb2Writer) node(n Node) {
+	if !visible(n) {
+		return
+	}
+	// Paragraphs start with their label, the other blocks are preceded by it
+	label := blockLabel(n)
+	if label != "" && n.Type != NODE_PARAGRAPH {
+		w.inline(fb2Style(CLASS_REVEALED, label) + " ")
+	}
+	switch n.Type {
+		case NODE_PARAGRAPH:
+			w.flush()
+			if label != "" {
+				w.inline(fb2Style(CLASS_REVEALED, label) + " ")
+			}
+			w.nodes(n.Children)
+			w.flush()
+
+		case NODE_MARKET:
+			var rows string
+			for _, c := range n.Children {
+				switch {
+					case !visible(c):
+					case c.Type == NODE_HEADER:
+						rows += "<tr><th colspan=\"3\">" + escapeXML(c.Text) + "</th></tr>\n"
+					case c.Price != nil:
+						buy, sell := c.Price.Buy, c.Price.Sell
+						if buy == "" {
+							buy = "-"
+						}
+						if sell == "" {
+							sell = "-"
+						}
+						rows += "<tr><td>" + w.inlineNode(c) + "</td><td>" + escapeXML(buy) + "</td><td>" + escapeXML(sell) + "</td></tr>\n"
+					default:
+						rows += "<tr><td colspan=\"3\">" + w.inlineNode(c) + "</td></tr>\n"
+				}
+			}
+			w.block("<table>\n" + FB2_SHOPHEADER + rows + "</table>\n")
+
+		case NODE_CHOICES, NODE_OUTCOMES:
+			var rows string
+			for _, c := range n.Children {
+				switch {
+					case !visible(c):
+					case isRow(c):
+						row := "<tr><th>" + escapeXML(rowLabel(c)) + "</th><td>" + w.inlineNodes(c.Children) + "</td>"
+						if c.Target != nil {
+							row += "<td>" + fmt.Sprintf(FB2_LINK, fb2ID(c.Target.ID), escapeXML(fmt.Sprintf(TXT_TURNTO, targetLabel(c)))) + "</td>"
+						}
+						rows += row + "</tr>\n"
+					case strings.TrimSpace(w.inlineNode(c)) != "":
+						rows += "<tr><td colspan=\"3\">" + w.inlineNode(c) + "</td></tr>\n"
+				}
+			}
+			if rows != "" {
+				w.block("<table>\n" + rows + "</table>\n")
+			}
+
+		case NODE_FIGHT:
+			f := n.Fight
+			w.block(fmt.Sprintf(FB2_FIGHT, escapeXML(f.Name), f.Combat, f.Defence, f.Stamina))
+			if *combat && fightHasOdds(bookProfessions(w.book), *f) {
+				w.block("<p>" + escapeXML(fightOddsText(bookProfessions(w.book), *f)) + "</p>\n")
+			}
+
+		case NODE_IMAGE:
+			if w.book != nil {
+				path := filepath.Join(w.book.Dir, n.File)
+				w.image(path, "img-" + path)
+			}
+
+		case NODE_CACHE:
+			if n.Tag == "moneycache" {
+				w.block("<p><emphasis>Please write the amount in your sheet instead.</emphasis></p>\n")
+			} else {
+				w.block("<subtitle>" + escapeXML(n.Text) + "</subtitle>\n<empty-line/>\n<empty-line/>\n<empty-line/>\n")
+			}
+
+		case NODE_HEADER:
+			w.block("<subtitle>" + escapeXML(n.Text) + "</subtitle>\n")
+
+		case NODE_NOTE:
+			// Only shown with -reveal, in a paragraph of its own
+			w.flush()
+			w.inline(w.inlineNode(n))
+			w.flush()
+
+		case NODE_CONDITION, NODE_ELEMENT:
+			if hasBlocks(n.Children) {
+				if n.Type == NODE_CONDITION {
+					w.inline(condition(n.Attributes, plainText(n.Children), fb2Style))
+				}
+				w.nodes(n.Children)
+			} else {
+				w.inline(w.inlineNode(n))
+			}
+
+		default:
+			w.inline(w.inlineNode(n))
+	}
+}
+
+func (w *fb2Writer) inlineNodes(nodes []Node) (out string) {
+	for _, n := range nodes {
+		out += w.inlineNode(n)
+	}
+	return strings.TrimSpace(out)
+}
+
+func (w *fb2Writer) inlineNode(n Node) (out string) {
+	if !visible(n) {
+		return
+	}
+	if label := inlineLabel(n); label != "" {
+		// Whatever the node turns out to be, the label goes in front of it
+		defer func() {
+			out = fb2Style(CLASS_REVEALED, label) + " " + out
+		}()
+	}
+	if n.Type == NODE_TEXT {
+		return escapeXML(n.Text)
+	}
+	if isRow(n) {
+		// A branch option that is not inside a table
+		var turnTo string
+		if n.Target != nil {
+			turnTo = fmt.Sprintf(FB2_LINK, fb2ID(n.Target.ID), escapeXML(fmt.Sprintf(TXT_TURNTO, targetLabel(n))))
+		}
+		return " " + joinWords(escapeXML(rowLabel(n)), w.inlineNodes(n.Children), turnTo) + " "
+	}
+	if s, ok := wording(n, bookProfessions(w.book), fb2Style); ok {
+		out = s
+	} else {
+		switch {
+			case n.Type == NODE_FIGHT:
+				out = fmt.Sprintf("<strong>%s</strong> (Combat %d, Defence %d, Stamina %d)", escapeXML(n.Fight.Name), n.Fight.Combat, n.Fight.Defence, n.Fight.Stamina)
+			case n.Type == NODE_GROUP:
+				for _, c := range n.Children {
+					if c.Tag == "text" {
+						out += w.inlineNodes(c.Children)
+					}
+				}
+			case n.Type == NODE_CONDITION:
+				out = joinWords(condition(n.Attributes, plainText(n.Children), fb2Style), w.inlineNodes(n.Children))
+			case n.Tag == "i" || n.Tag == "em":
+				out = "<emphasis>" + w.inlineNodes(n.Children) + "</emphasis>"
+			case n.Tag == "b" || n.Tag == "strong":
+				out = "<strong>" + w.inlineNodes(n.Children) + "</strong>"
+			default:
+				for _, c := range n.Children {
+					out += w.inlineNode(c)
+				}
+		}
+	}
+	if n.Target != nil && !isRow(n) {
+		out = fmt.Sprintf(FB2_LINK, fb2ID(n.Target.ID), out)
+	}
+	return
+}
+
+func fb2Style(class, text string) string {
+	switch class {
+		case CLASS_ITEM, CLASS_RESURRECTION:
+			return "<strong>" + escapeXML(text) + "</strong>"
+		case CLASS_REVEALED:
+			return "<emphasis>" + escapeXML(text) + "</emphasis>"
+		default:
+			return escapeXML(text)
+	}
+}
+
+func fb2Profession(p Profession) (out string) {
+	out = "<subtitle>" + escapeXML(p.Name) + "</subtitle>\n<table>\n<tr>"
+	for _, a := range ABILITIES {
+		out += "<th>" + a + "</th>"
+	}
+	out += "</tr>\n<tr>"
+	for _, a := range p.Abilities {
+		out += "<td>" + escapeXML(a) + "</td>"
+	}
+	out += "</tr>\n"
+	out += "<tr><th colspan=\"2\">Stamina</th><th colspan=\"2\">Rank</th><th colspan=\"2\">Gold</th></tr>\n"
+	out += fmt.Sprintf("<tr><td colspan=\"2\">%s</td><td colspan=\"2\">%s</td><td colspan=\"2\">%s</td></tr>\n", escapeXML(p.Stamina), escapeXML(p.Rank), escapeXML(p.Gold))
+	out += "<tr><th colspan=\"6\">Starting equipment</th></tr>\n"
+	for _, e := range p.Equipment {
+		out += "<tr><th colspan=\"2\">" + escapeXML(capitalize(e.Type)) + "</th><td colspan=\"4\">" + escapeXML(equipmentName(e)) + "</td></tr>\n"
+	}
+	out += "</table>\n"
+	return
+}
+
+// appendices adds the Adventure Sheet, the Ship's Manifest, the Codewords
+// and the pre-generated characters at the end of the book.
+func (w *fb2Writer) appendices(document Document) {
+	w.sheet("sheet", "Adventure Sheet", hero)
+
+	fmt.Fprintf(&w.out, FB2_SECTION_OPEN, "manifest", "Ship's Manifest")
+	rows := "<tr>"
+	for _, c := range MANIFEST_COLUMNS {
+		rows += "<th>" + escapeXML(c) + "</th>"
+	}
+	rows += "</tr>\n"
+	for i := 0; i < MANIFEST_ROWS; i++ {
+		rows += "<tr>"
+		for _, v := range manifestRow(i) {
+			rows += "<td> " + escapeXML(v) + "</td>"
+		}
+		rows += "</tr>\n"
+	}
+	w.block("<table>\n" + rows + "</table>\n")
+	w.out.WriteString(FB2_SECTION_CLOSE)
+
+	fmt.Fprintf(&w.out, FB2_SECTION_OPEN, "codewords", "Codewords")
+	for _, n := range codewordBooks() {
+		fmt.Fprintf(&w.out, FB2_SECTION_OPEN, fmt.Sprintf("cd%d", n), escapeXML(bookTitle(n)))
+		for _, word := range readCodewords(n) {
+			w.block("<p>" + codewordBox(word) + " " + escapeXML(word) + "</p>\n")
+		}
+		w.out.WriteString(FB2_SECTION_CLOSE)
+	}
+	w.out.WriteString(FB2_SECTION_CLOSE)
+
+	if adventurers := pregeneratedAdventurers(document); len(adventurers) > 0 {
+		fmt.Fprintf(&w.out, FB2_SECTION_OPEN, "pregenerated", PREGENERATED_TITLE)
+		for i := range adventurers {
+			w.sheet(fb2ID(adventurerID(adventurers[i])), escapeXML(adventurerTitle(adventurers[i])), &adventurers[i])
+		}
+		w.out.WriteString(FB2_SECTION_CLOSE)
+	}
+
+	for _, r := range reports(document) {
+		w.report(r)
+	}
+}
+
+func (w *fb2Writer) report(r Report) {
+	fmt.Fprintf(&w.out, FB2_SECTION_OPEN, r.ID, escapeXML(r.Title))
+	if r.Intro != "" {
+		w.block("<p>" + escapeXML(r.Intro) + "</p>\n")
+	}
+	for _, t := range r.Tables {
+		rows := ""
+		if len(t.Header) > 0 {
+			rows += "<tr>"
+			for _, h := range t.Header {
+				rows += "<th>" + escapeXML(h) + "</th>"
+			}
+			rows += "</tr>\n"
+		}
+		for _, row := range t.Rows {
+			rows += "<tr>"
+			for _, c := range row {
+				var texts []string
+				for _, p := range c.parts() {
+					text := escapeXML(p.Text)
+					if p.Target != nil {
+						text = fmt.Sprintf(FB2_LINK, fb2ID(p.Target.ID), text)
+					}
+					texts = append(texts, text)
+				}
+				rows += "<td>" + strings.Join(texts, ", ") + "</td>"
+			}
+			rows += "</tr>\n"
+		}
+		var title string
+		if t.Title != "" {
+			title = "<subtitle>" + escapeXML(t.Title) + "</subtitle>\n"
+		}
+		w.block(title + "<table>\n" + rows + "</table>\n")
+	}
+	w.out.WriteString(FB2_SECTION_CLOSE)
+}
+
+// sheet adds an Adventure Sheet, filled in with an adventurer if there is one.
+func (w *fb2Writer) sheet(id, title string, a *Adventurer) {
+	fmt.Fprintf(&w.out, FB2_SECTION_OPEN, id, title)
+	rows := ""
+	for _, f := range SHEET_FIELDS {
+		value := escapeXML(sheetValue(a, f))
+		if t, text := sheetLink(a, f); t != nil {
+			// Reports keep their own id
+			id := t.ID
+			if t.Section != "" {
+				id = fb2ID(id)
+			}
+			value = fmt.Sprintf(FB2_LINK, id, escapeXML(text))
+		}
+		rows += "<tr><th>" + escapeXML(f) + "</th><td> " + value + "</td></tr>\n"
+	}
+	for i := 1; i <= SHEET_POSSESSIONS; i++ {
+		rows += fmt.Sprintf("<tr><th>Possession %d</th><td> %s</td></tr>\n", i, escapeXML(possessionValue(a, i-1)))
+	}
+	rows += "<tr><th>" + SHEET_BLESSINGS + "</th><td> " + escapeXML(sheetValue(a, SHEET_BLESSINGS)) + "</td></tr>\n"
+	w.block("<table>\n" + rows + "</table>\n")
+	w.out.WriteString(FB2_SECTION_CLOSE)
+}
+
+// fb2ID turns an anchor into a valid XML id, which cannot start with a digit nor contain spaces.
+func fb2ID(id string) string {
+	return "s" + strings.Map(func(r rune) rune {
+		switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+				return r
+			default:
+				return '_'
+		}
+	}, id)
+}
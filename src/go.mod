@@ -1,3 +1,3 @@
-module main
+module jaflToHtml
 
 go 1.22.2
@@ -0,0 +1,241 @@
+package main
+
+// --- INTERACTIVE MODE ---
+// With -interactive, this script is appended to the HTML so that the book can be played in a browser.
+// Everything is kept in localStorage, under keys prefixed by the path of the page,
+// so that the file keeps working offline and on its own.
+//  - The cells of the Adventure Sheet and of the Ship's Manifest can be edited.
+//  - Codewords and section tickboxes can be ticked by clicking them.
+//  - Rolls and checks (the 'dice' spans added by replace()) get a button that rolls the dice.
+//    Checks add the ability written in the Adventure Sheet, rank checks compare the roll with the Rank.
+// It needs the HTML versions of Sheet.html and Manifest.html, since there is nothing to edit in the pictures.
+
+const INTERACTIVE_SCRIPT =
+`
+<style>
+	.editable {
+		background-color: #fffbe6;
+		min-width: 2em;
+	}
+	.tickbox, .codewords li {
+		cursor: pointer;
+	}
+	.codewords li.ticked {
+		list-style-type: "☑ " !important;
+	}
+	.dice-roller {
+		margin-left: 0.5em;
+		font-size: small;
+	}
+	.dice-result {
+		margin-left: 0.5em;
+		font-weight: bold;
+	}
+	@media print {
+		.dice-roller, .dice-result, .clear-progress {
+			display: none;
+		}
+		.editable {
+			background-color: transparent;
+		}
+	}
+</style>
+<script>
+(function () {
+	var PREFIX = "jafl:" + location.pathname + ":";
+	var TICKBOX = "◻";
+	var TICKED = "☑";
+
+	function load(key) {
+		try {
+			return localStorage.getItem(PREFIX + key);
+		} catch (e) {
+			return null;
+		}
+	}
+
+	function save(key, value) {
+		try {
+			if (value === null || value === "") {
+				localStorage.removeItem(PREFIX + key);
+			} else {
+				localStorage.setItem(PREFIX + key, value);
+			}
+		} catch (e) {}
+	}
+
+	function toggle(key) {
+		// Unticking is remembered too, so that what an imported saved game ticked stays unticked
+		save(key, load(key) === "1" ? "0" : "1");
+		return load(key) === "1";
+	}
+
+	// The cell of a label of a sheet: next to it, or else below it
+	function labelCell(label) {
+		var cell = label.nextElementSibling;
+		if (!cell || cell.tagName !== "TD") {
+			// Rank, Stamina, Defence and Money have their cells in the next row
+			var row = label.parentNode.nextElementSibling;
+			cell = row && row.children[Array.prototype.indexOf.call(label.parentNode.children, label)];
+		}
+		return cell;
+	}
+
+	function editable(cell, key) {
+		cell.contentEditable = "true";
+		cell.classList.add("editable");
+		if (load(key) !== null) {
+			cell.textContent = load(key);
+		}
+		cell.addEventListener("input", function () {
+			save(key, cell.textContent.trim());
+		});
+	}
+
+	// Adventure Sheet and Ship's Manifest
+	// Cells are kept under the id of their page and the name of their field rather than their place,
+	// so that they stay where they were when the book is converted again with other options
+	document.querySelectorAll(".sheet").forEach(function (page) {
+		page.querySelectorAll("th").forEach(function (label) {
+			var cell = labelCell(label);
+			if (cell && cell.classList.contains("field")) {
+				editable(cell, "cell:" + page.id + ":" + label.textContent.trim());
+			}
+		});
+		page.querySelectorAll("td.possession").forEach(function (cell, i) {
+			editable(cell, "cell:" + page.id + ":possession " + (i + 1));
+		});
+	});
+	document.querySelectorAll(".manifest").forEach(function (page) {
+		var columns = page.querySelectorAll("th");
+		page.querySelectorAll("tr").forEach(function (row, r) {
+			row.querySelectorAll("td").forEach(function (cell, c) {
+				editable(cell, "cell:" + page.id + ":" + (columns[c] ? columns[c].textContent.trim() : c) + ":" + r);
+			});
+		});
+	});
+
+	// Codewords
+	document.querySelectorAll(".codewords").forEach(function (page) {
+		page.querySelectorAll("li").forEach(function (li) {
+			var key = "codeword:" + page.id + ":" + li.textContent.trim();
+			if (li.classList.contains("ticked") && load(key) === null) {
+				save(key, "1");
+			}
+			li.classList.toggle("ticked", load(key) === "1");
+			li.addEventListener("click", function () {
+				li.classList.toggle("ticked", toggle(key));
+			});
+		});
+	});
+
+	// Section tickboxes
+	document.querySelectorAll("h2[id] .tickboxes").forEach(function (span) {
+		// Boxes already ticked by an imported saved game start ticked
+		var boxes = span.textContent.match(/[◻☑]/g) || [];
+		var id = span.parentNode.id;
+		span.textContent = "";
+		for (var i = 0; i < boxes.length; i++) {
+			(function (key) {
+				if (boxes[i] === TICKED && load(key) === null) {
+					save(key, "1");
+				}
+				var box = document.createElement("span");
+				box.className = "tickbox";
+				box.textContent = " " + (load(key) === "1" ? TICKED : TICKBOX);
+				box.addEventListener("click", function () {
+					box.textContent = " " + (toggle(key) ? TICKED : TICKBOX);
+				});
+				span.appendChild(box);
+			})("box:" + id + ":" + i);
+		}
+	});
+
+	// Dice
+	function roll(dice) {
+		var faces = [];
+		var total = 0;
+		for (var i = 0; i < dice; i++) {
+			var face = 1 + Math.floor(Math.random() * 6);
+			faces.push(face);
+			total += face;
+		}
+		return { faces: faces, total: total };
+	}
+
+	// An ability raised by a possession is written "4 (5)": the number in parentheses counts the bonus
+	function scoreValue(text) {
+		var boosted = text.match(/\((-?\d+)\)/);
+		return parseInt(boosted ? boosted[1] : text, 10);
+	}
+
+	// Reads a score from the Adventure Sheet, looking for the cell next to its label
+	function score(name) {
+		var labels = document.querySelectorAll(".sheet th");
+		for (var i = 0; i < labels.length; i++) {
+			if (labels[i].textContent.trim().toUpperCase() === name.trim().toUpperCase()) {
+				var cell = labelCell(labels[i]);
+				return cell ? scoreValue(cell.textContent) : NaN;
+			}
+		}
+		return NaN;
+	}
+
+	document.querySelectorAll(".dice").forEach(function (span) {
+		var button = document.createElement("button");
+		var result = document.createElement("span");
+		button.type = "button";
+		button.className = "dice-roller";
+		button.textContent = "Roll";
+		result.className = "dice-result";
+		button.addEventListener("click", function (event) {
+			event.preventDefault();
+			event.stopPropagation();
+			var r = roll(parseInt(span.dataset.dice, 10) || 2);
+			var text = r.faces.join(" + ");
+			if (r.faces.length > 1) {
+				text += " = " + r.total;
+			}
+			if (span.dataset.level) {
+				var ability = score(span.dataset.ability);
+				var level = parseInt(span.dataset.level, 10);
+				if (isNaN(ability)) {
+					text += " (write your " + span.dataset.ability + " in the Adventure Sheet to add it)";
+				} else {
+					text += " + " + ability + " = " + (r.total + ability) + (r.total + ability > level ? ": success!" : ": failure");
+				}
+			} else if (span.dataset.rank) {
+				var rank = score("Rank");
+				if (!isNaN(rank)) {
+					text += r.total < rank ? ": success!" : ": failure";
+				}
+			}
+			result.textContent = text;
+		});
+		span.appendChild(button);
+		span.appendChild(result);
+	});
+
+	// A way to start over
+	var sheet = document.getElementById("sheet");
+	if (sheet) {
+		var clear = document.createElement("button");
+		clear.type = "button";
+		clear.className = "clear-progress";
+		clear.textContent = "Clear saved progress";
+		clear.addEventListener("click", function () {
+			if (!confirm("Clear the Adventure Sheet, the Ship's Manifest, the codewords and the tickboxes?")) {
+				return;
+			}
+			for (var i = localStorage.length - 1; i >= 0; i--) {
+				if (localStorage.key(i).indexOf(PREFIX) === 0) {
+					localStorage.removeItem(localStorage.key(i));
+				}
+			}
+			location.reload();
+		});
+		sheet.appendChild(clear);
+	}
+})();
+</script>
+`
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// --- ITEM INDEX ---
+// With -items, the 'Item index' report lists every item, weapon, armour, tool, ship and cargo of the books,
+// once for each set of bonuses, with every section where it can be found, bought or sold.
+
+// Types of the things that can be carried, as opposed to Shards, Stamina, titles...
+var INDEX_TYPES = []string{"weapon", "armour", "item", "tool", "ship", "cargo"}
+
+type indexEntry struct {
+	item Item
+	places []indexPlace
+}
+
+type indexPlace struct {
+	section Section
+	how string
+	buy, sell string
+}
+
+// itemProperties describes the bonuses of an item, like "+1 to sanctity".
+func itemProperties(it Item) (properties string) {
+	if capacity, ok := SHIP_CAPACITY[it.Name]; ok {
+		properties += "capacity: " + strconv.Itoa(capacity) + ", "
+	}
+	if it.Bonus != "" {
+		properties += "+" + it.Bonus
+	}
+	if it.Ability != "" {
+		properties += " to " + it.Ability
+	}
+	return strings.TrimSpace(strings.TrimSuffix(properties, ", "))
+}
+
+// itemPlace tells how an item is got or given in a section.
+func itemPlace(s Section, n Node) (p indexPlace, ok bool) {
+	p.section = s
+	switch {
+		case n.Price != nil:
+			p.how, p.buy, p.sell = "Market", n.Price.Buy, n.Price.Sell
+		case n.Tag == "buy":
+			p.how, p.buy = "Bought", n.Attributes["shards"]
+		case n.Tag == "sell":
+			p.how, p.sell = "Sold", n.Attributes["shards"]
+		case n.Tag == "trade":
+			p.how = "Traded"
+		case n.Tag == "lose":
+			return p, false
+		default:
+			p.how = "Found"
+	}
+	return p, true
+}
+
+// itemIndex gathers the items of the books, in the order of their names.
+func itemIndex(document Document) (entries []indexEntry) {
+	for _, bk := range document.Books {
+		for _, s := range bk.Sections {
+			for _, n := range findNodes(s.Content, NODE_ITEM) {
+				if !slices.Contains(INDEX_TYPES, n.Item.Type) {
+					continue
+				}
+				p, ok := itemPlace(s, n)
+				if !ok {
+					continue
+				}
+				i := slices.IndexFunc(entries, func(e indexEntry) bool {
+					return strings.EqualFold(e.item.Name, n.Item.Name) && e.item.Type == n.Item.Type &&
+						e.item.Bonus == n.Item.Bonus && strings.EqualFold(e.item.Ability, n.Item.Ability)
+				})
+				if i < 0 {
+					entries = append(entries, indexEntry{item: *n.Item})
+					i = len(entries) - 1
+				}
+				entries[i].places = append(entries[i].places, p)
+			}
+		}
+	}
+	slices.SortStableFunc(entries, func(a, b indexEntry) int {
+		if c := strings.Compare(strings.ToLower(a.item.Name), strings.ToLower(b.item.Name)); c != 0 {
+			return c
+		}
+		return strings.Compare(itemProperties(a.item), itemProperties(b.item))
+	})
+	return
+}
+
+// items is the report of every item of the books, with the sections they are in.
+func items(document Document) (r Report) {
+	r.ID = "item-index"
+	r.Title = "Item index"
+	r.Intro = "Every item of the books, with its bonuses and the sections where it can be found, bought or sold."
+	table := ReportTable{Header: []string{"Item", "Type", "Properties", "Section", "How", "Buy", "Sell"}}
+	for _, e := range itemIndex(document) {
+		for i, p := range e.places {
+			row := []ReportCell{{}, {}, {}}
+			// The item is only named on its first row, so that its places read as a group
+			if i == 0 {
+				row = []ReportCell{{Text: e.item.Name}, {Text: e.item.Type}, {Text: itemProperties(e.item)}}
+			}
+			row = append(row, bookSectionCell(p.section), ReportCell{Text: p.how}, ReportCell{Text: p.buy}, ReportCell{Text: p.sell})
+			table.Rows = append(table.Rows, row)
+		}
+	}
+	r.Tables = append(r.Tables, table)
+	return
+}
@@ -4,15 +4,17 @@ import (
 	"archive/zip"
 	"bufio"
 	"encoding/xml"
-	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"runtime"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 )
 
@@ -20,6 +22,7 @@ import (
 
 const DEFAULT_DIR = "."
 const DEFAULT_OUTPUT = "output.html"
+const DEFAULT_FORMAT = FORMAT_HTML
 const DESIRED_EXT = ".xml"
 const ZIP_EXT = ".zip"
 
@@ -66,8 +69,14 @@ const WORLDMAP_NAME = "global.jpg"
 const RULES_NAME = "Rules.xml"
 const QUICKRULES_NAME = "QuickRules.xml"
 
-const BEFORE = true
-const AFTER = false
+const (
+	FORMAT_HTML = "html"
+	FORMAT_JSON = "json"
+	FORMAT_FB2 = "fb2"
+	FORMAT_TEXT = "txt"
+	FORMAT_GEMTEXT = "gmi"
+	FORMAT_ODT = "odt"
+)
 
 const MENU =
 `<div class="menu" id="menu">
@@ -119,11 +128,15 @@ type element struct {
 	Name string
 	Content string
 	Attributes map[string]string
+	Children []Node
+	file *parsed	// File the element was parsed from
 }
 
 type stack []element
 
 var root string
+// Where progress messages go
+var progress io.Writer = os.Stdout
 var output string
 var dir string
 var book int
@@ -159,12 +172,66 @@ var title = [...]string{
 }
 
 // Flags
-var b *int
+var b = flag.Int("b", 0, "Specify a single book number to process")
+var format = flag.String("format", DEFAULT_FORMAT, "Output format: html, json, fb2, txt, gmi or odt")
+var width = flag.Int("width", DEFAULT_WIDTH, "Line width of the txt format")
+var interactive = flag.Bool("interactive", false, "Keep the sheet, codewords and tickboxes in the browser, and add dice rollers (html format only)")
+var pregenerated = flag.Bool("pregenerated", false, "Add a filled-in Adventure Sheet for every starting adventurer")
+var odds = flag.Bool("odds", false, "Annotate checks with the chance of success of each starting profession, and list the hardest checks")
+var combat = flag.Bool("combat", false, "Annotate fights with the chance of each starting profession to win them, and add these odds to the bestiary")
+var weaponBonus = flag.Int("weapon-bonus", 0, "Bonus added to the weapons of the professions for -combat")
+var armourBonus = flag.Int("armour-bonus", 0, "Bonus added to the armour of the professions for -combat")
+var tradeReport = flag.Bool("trade", false, "List the prices of every market, and the most profitable cargo runs")
+var tradeCSV = flag.String("trade-csv", "", "Export the prices of every market, and the most profitable cargo runs, to this CSV file")
+var itemsReport = flag.Bool("items", false, "List every item, with its bonuses and the sections where it can be found, bought or sold")
+var bestiaryReport = flag.Bool("bestiary", false, "List every enemy of the books in alphabetical order, with the sections where it is fought")
+var templesReport = flag.Bool("temples", false, "List every place where resurrection can be arranged or a god worshipped, by god")
+var reveal = flag.Bool("reveal", false, "Print the hidden tags, section descriptions and modifiers that only the game engine sees, for proofreading and game masters")
+var tagsFile = flag.String("tags", "", "JSON file telling how to render the tags the converter does not know")
+var checkReproducible = flag.Bool("check-reproducible", false, "Convert the books twice and check that both outputs are the same")
+var jobs = flag.Int("jobs", runtime.NumCPU(), "Number of files parsed at the same time")
+var keepGoing = flag.Bool("keep-going", false, "Skip the sections of malformed files instead of stopping, and list the errors at the end")
+var cacheDir = flag.String("cache", "", "Directory where converted files are kept, so that the next conversions only convert the files that changed")
+var importFile = flag.String("import", "", "JAFL saved game (or game saved by the play command) used to fill in the sheet, manifest, codewords and tickboxes")
+
+// Commands
+const COMMAND_PLAY = "play"
+const COMMAND_NEWCHAR = "newchar"
+const COMMAND_DIFF = "diff"
+const COMMAND_STATS = "stats"
 
 func main() {
-	b = flag.Int("b", 0, "Specify a single book number to process")
+	// Commands have flags of their own
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+			case COMMAND_PLAY:
+				play(os.Args[2:])
+				return
+			case COMMAND_NEWCHAR:
+				newchar(os.Args[2:])
+				return
+			case COMMAND_DIFF:
+				diff(os.Args[2:])
+				return
+			case COMMAND_STATS:
+				stats(os.Args[2:])
+				return
+		}
+	}
 
 	flag.Parse()
+	// Runs last, once the output is saved
+	defer exitWithDiagnostics()
+
+	switch *format {
+		case FORMAT_HTML, FORMAT_JSON, FORMAT_FB2, FORMAT_TEXT, FORMAT_GEMTEXT, FORMAT_ODT:
+		default:
+			usageError(flag.CommandLine, fmt.Errorf("Unknown output format %q", *format))
+	}
+
+	if *tagsFile != "" {
+		check(loadTagRules(*tagsFile))
+	}
 
 	// Define the root directory
 	root = flag.Arg(0)
@@ -173,186 +240,290 @@ func main() {
 		fmt.Println("Directory not defined. Operating in the current directory...")
 	}
 
+	// Import the adventurer that fills in the sheets
+	if *importFile != "" {
+		fmt.Print("Importing saved game... ")
+		imported, err := importSave(*importFile)
+		check(err)
+		hero = &imported
+		fmt.Println("imported", hero.Name)
+	}
+
 	// Define the output file
 	output = flag.Arg(1)
 	if output == "" {
-		output = DEFAULT_OUTPUT
-		fmt.Println("Output file not specified. Output will be saved in", DEFAULT_OUTPUT)
+		output = stripExt(DEFAULT_OUTPUT) + "." + *format
+		fmt.Println("Output file not specified. Output will be saved in", output)
 	}
 
-	// List all book directories
-	var books []string
-	readDir, readErr := os.ReadDir(root)
-	check(readErr)
-	for _, f :=  range readDir {
-		if filepath.Ext(f.Name()) == ZIP_EXT {
-			books = append(books, f.Name())
+	if *checkReproducible {
+		if code := reproducible(); code != EXIT_OK {
+			os.Exit(code)
 		}
+		return
 	}
-	slices.Sort(books)
 
-	// Unzip all book directories
-	if existDir("book1", "book2", "book3", "book4", "book5", "book6") {
-		fmt.Println("Located book folders.")
-	} else {
-		fmt.Println("Book folders not found. Extracting from root...")
-		for _, d := range books {
-			fmt.Println("Extracting", d)
-			// Make a directory to store the extracted files
-			os.Mkdir(stripExt(d), 0700)
-
-			// Open the archive
-			r, err := zip.OpenReader(filepath.Join(root, d))
-			check(err)
-
-			// Cycle through all files in archive
-			for _, f := range r.File {
-				fmt.Print("Extracting ", f.Name, "... ")
-				// Open the file
-				rc, err := f.Open()
-				check(err)
-
-				// Save the file
-				rb, err := os.Create(filepath.Join(stripExt(d), f.Name))
-				check(err)
-				_, err = io.Copy(rb, rc)
-				check(err)
-				rc.Close()
-				rb.Close()
-				fmt.Println("done")
-			}
-			r.Close()
-		}
+	if *cacheDir != "" {
+		check(initCache())
 	}
 
-	var content string
+	var document Document
+	document.Schema, document.Version = MODEL_SCHEMA, MODEL_VERSION
 
-	// Cycle through each book
-	for book, dir = range books {
-		book++
-		// If the -b flag was used, only operate on a certain book
-		if *b != 0 && book != *b{
-			continue
-		}
-		dir = stripExt(dir)
-		fmt.Printf("\n--- CONVERTING BOOK %d ---\n", book)
-		fmt.Println("Directory:", dir)
-		fmt.Println()
-
-		// Import Adventurers.xml
-		fmt.Printf("Processing file %s... ", ADVENTURERS)
-		updateStats(filepath.Join(dir, ADVENTURERS))
-		fmt.Println("loaded starting classes")
-
-		// Make a sorted slice of all files in the book
-		var filenames []string
-		readDir, err := os.ReadDir(dir)
+	// The HTML is written as it is converted, in the order it is read in.
+	// The other formats are saved from the model at the end, and skip it.
+	var out io.Writer = io.Discard
+	if *format == FORMAT_HTML {
+		fmt.Print("Creating output file... ")
+		htmlFile, err := os.Create(output)
 		check(err)
-		for _, f :=  range readDir {
-			filenames = append(filenames, f.Name())
-		}
-		slices.SortFunc(filenames, betterSort)
-
-		// Add title page
-		fmt.Print("Adding Title... ")
-			content += fmt.Sprintf(BOOK_TITLE, title[book])
-		fmt.Println("Done")
-
-		// Add map
-		fmt.Print("Importing Map... ")
-			content += fmt.Sprintf(MAP_ATTACHMENT, filepath.Join(dir, region[book] + ".JPG"), "map-"+linkify(region[book]))
+		defer htmlFile.Close()
+		buffered := bufio.NewWriter(htmlFile)
+		defer func() { check(buffered.Flush()) }()
+		out = buffered
 		fmt.Println("done")
-
-		// Process all files
-		for _, fn := range filenames {
-			fmt.Printf("Processing file %s... ", fn)
-			if strings.Contains(fn, "temp") || strings.Contains(fn, "old") || fn == ADVENTURERS{
-				fmt.Println("ignored")
-				continue
-			}
-			if fn == ADVENTURERS {
-				continue
-			}
-			fn = filepath.Join(dir, fn)
-			switch filepath.Ext(fn) {
-				case DESIRED_EXT:
-					page, err := parse(fn)
-					check(err)
-					content += page
-					fmt.Println("done!")
-				default:
-					fmt.Println("ignored")
-			}
-		}
-
-		fmt.Print("--- DONE ---\n\n")
 	}
 
-	book = 0
+	// Add header
+	io.WriteString(out, HEAD)
 
-	// Add various materials
-	fmt.Print("Importing Adventure Sheet... ")
-	load(SHEET_NAME, &content, AFTER)
+	// Add front matter
+	fmt.Print("Importing Cover... ")
+	io.WriteString(out, load(COVER_NAME))
 	fmt.Println("done")
 
-	fmt.Print("Importing Ship's Manifest... ")
-	load(MANIFEST_NAME, &content, AFTER)
+	fmt.Print("Importing Rules... ")
+	copyFromRoot(RULES_NAME)
+	rules := collect(parseCached(RULES_NAME, cacheScope()))
+	io.WriteString(out, rules.output.String())
+	document.Rules = rules.sections
+	fmt.Println("done")
+
+	fmt.Print("Importing Quick Rules... ")
+	copyFromRoot(QUICKRULES_NAME)
+	rules = collect(parseCached(QUICKRULES_NAME, cacheScope()))
+	io.WriteString(out, rules.output.String())
+	document.Rules = append(document.Rules, rules.sections...)
 	fmt.Println("done")
 
 	fmt.Print("Importing World Map... ")
 	copyFromRoot(WORLDMAP_NAME)
-	content = fmt.Sprintf(MAP_ATTACHMENT, WORLDMAP_NAME, "map-world") + content
+	fmt.Fprintf(out, MAP_ATTACHMENT, WORLDMAP_NAME, "map-world")
 	fmt.Println("done")
 
-	fmt.Print("Importing Quick Rules... ")
-	copyFromRoot(QUICKRULES_NAME)
-	parsedText, err := parse(QUICKRULES_NAME)
-	check(err)
-	content = parsedText + content
+	// Cycle through each book
+	for i, d := range listBooks() {
+		// If the -b flag was used, only operate on a certain book
+		if *b != 0 && i+1 != *b {
+			continue
+		}
+		document.Books = append(document.Books, loadBook(i+1, stripExt(d), out))
+	}
+
+	book = 0
+
+	// Add various materials
+	fmt.Print("Importing Adventure Sheet... ")
+	sheet := load(SHEET_NAME)
+	io.WriteString(out, fillSheet(sheet))
 	fmt.Println("done")
 
-	fmt.Print("Importing Rules... ")
-	copyFromRoot(RULES_NAME)
-	parsedText, err = parse(RULES_NAME)
-	check(err)
-	content = parsedText + content
+	fmt.Print("Importing Ship's Manifest... ")
+	loadFilled(MANIFEST_NAME, out, fillManifest)
 	fmt.Println("done")
 
 	fmt.Print("Importing Codewords... ")
 	if *b != 0 {
-		load(fmt.Sprintf(CODEWORDS_NAME, strconv.Itoa(*b)), &content, AFTER)
+		loadFilled(fmt.Sprintf(CODEWORDS_NAME, strconv.Itoa(*b)), out, fillCodewords)
 	} else {
 		for i := 1; i <= 6; i++ {
-			load(fmt.Sprintf(CODEWORDS_NAME, strconv.Itoa(i)), &content, AFTER)
+			loadFilled(fmt.Sprintf(CODEWORDS_NAME, strconv.Itoa(i)), out, fillCodewords)
 		}
 	}
 	fmt.Println("done")
 
-	fmt.Print("Importing Cover... ")
-	load(COVER_NAME, &content, BEFORE)
-	fmt.Println("done")
+	if *pregenerated {
+		fmt.Print("Adding pre-generated characters... ")
+		io.WriteString(out, pregeneratedPages(document, sheet))
+		fmt.Println("done")
+	}
 
-	// Add header
-	content = HEAD + content
+	printUnknownTags(os.Stdout)
+	printDiagnostics(os.Stdout)
 
-	// Prepare the output file
-	fmt.Print("Creating output file... ")
-	outFile, err := os.Create(output)
-	check(err)
-	defer outFile.Close()
-	fmt.Println("done")
+	if *tradeCSV != "" {
+		fmt.Print("Exporting trade prices... ")
+		check(writeTradeCSV(*tradeCSV, document))
+		fmt.Println("done")
+	}
 
-	// Save to HTML
-	reader := strings.NewReader(content)
-	fmt.Println("Saving to HTML...")
-	htmlFile, err := os.Create(output)
-	io.Copy(htmlFile, reader)
-	htmlFile.Close()
-	fmt.Println("Done")
+	if hasReports() {
+		fmt.Print("Adding reports... ")
+		io.WriteString(out, htmlReports(document))
+		fmt.Println("done")
+	}
+
+	if *interactive {
+		fmt.Print("Adding interactive script... ")
+		io.WriteString(out, INTERACTIVE_SCRIPT)
+		fmt.Println("done")
+	}
 
+	switch *format {
+		case FORMAT_JSON:
+			fmt.Print("Saving to JSON... ")
+			check(writeJSON(output, document))
+			fmt.Println("done")
+		case FORMAT_FB2:
+			fmt.Print("Saving to FB2... ")
+			check(writeFB2(output, document))
+			fmt.Println("done")
+		case FORMAT_TEXT:
+			fmt.Print("Saving to plain text... ")
+			check(writeText(output, document))
+			fmt.Println("done")
+		case FORMAT_ODT:
+			fmt.Print("Saving to ODT... ")
+			check(writeODT(output, document))
+			fmt.Println("done")
+		case FORMAT_GEMTEXT:
+			// Gemtext is saved as a capsule directory, named after the output file
+			output = stripExt(output)
+			fmt.Print("Saving to Gemtext... ")
+			check(writeGemtext(output, document))
+			fmt.Println("done")
+	}
 	fmt.Println("\nFinished! Output saved in ", output)
 }
 
+// listBooks finds the book archives in the root directory, and extracts them if needed.
+func listBooks() (books []string) {
+	// List all book directories
+	readDir, readErr := os.ReadDir(root)
+	check(readErr)
+	for _, f :=  range readDir {
+		if filepath.Ext(f.Name()) == ZIP_EXT {
+			books = append(books, f.Name())
+		}
+	}
+	slices.Sort(books)
+
+	// Unzip all book directories
+	if existDir("book1", "book2", "book3", "book4", "book5", "book6") {
+		fmt.Fprintln(progress, "Located book folders.")
+	} else {
+		fmt.Fprintln(progress, "Book folders not found. Extracting from root...")
+		for _, d := range books {
+			extractBook(filepath.Join(root, d), stripExt(d))
+		}
+	}
+	return
+}
+
+// extractBook unzips the archive of a book into a directory.
+func extractBook(archive, d string) {
+	fmt.Fprintln(progress, "Extracting", filepath.Base(archive))
+	// Make a directory to store the extracted files
+	os.Mkdir(d, 0700)
+
+	// Open the archive
+	r, err := zip.OpenReader(archive)
+	check(err)
+
+	// Cycle through all files in archive
+	for _, f := range r.File {
+		fmt.Fprint(progress, "Extracting ", f.Name, "... ")
+		// Open the file
+		rc, err := f.Open()
+		check(err)
+
+		// Save the file
+		rb, err := os.Create(filepath.Join(d, f.Name))
+		check(err)
+		_, err = io.Copy(rb, rc)
+		check(err)
+		rc.Close()
+		rb.Close()
+		fmt.Fprintln(progress, "done")
+	}
+	r.Close()
+}
+
+// loadBook parses all the files of a book, writes its HTML and returns its model.
+func loadBook(n int, d string, out io.Writer) (bookModel Book) {
+	book, dir = n, d
+	fmt.Fprintf(progress, "\n--- CONVERTING BOOK %d ---\n", book)
+	fmt.Fprintln(progress, "Directory:", dir)
+	fmt.Fprintln(progress)
+
+	// Import Adventurers.xml
+	fmt.Fprintf(progress, "Processing file %s... ", ADVENTURERS)
+	updateStats(filepath.Join(dir, ADVENTURERS))
+	fmt.Fprintln(progress, "loaded starting classes")
+
+	// Make a sorted slice of all files in the book
+	var filenames []string
+	readDir, err := os.ReadDir(dir)
+	check(err)
+	for _, f :=  range readDir {
+		filenames = append(filenames, f.Name())
+	}
+	slices.SortFunc(filenames, betterSort)
+
+	// Add title page
+	fmt.Fprint(progress, "Adding Title... ")
+		fmt.Fprintf(out, BOOK_TITLE, title[book])
+	fmt.Fprintln(progress, "Done")
+
+	// Add map
+	fmt.Fprint(progress, "Importing Map... ")
+		fmt.Fprintf(out, MAP_ATTACHMENT, filepath.Join(dir, region[book] + ".JPG"), "map-"+linkify(region[book]))
+	fmt.Fprintln(progress, "done")
+
+	bookModel = Book{Number: book, Title: title[book], Region: region[book], Dir: dir, Map: filepath.Join(dir, region[book] + ".JPG")}
+	bookModel.Professions = startingProfessions()
+
+	// Parse all files at once
+	parsable := func(fn string) bool {
+		ignored := strings.Contains(fn, "temp") || strings.Contains(fn, "old") || fn == ADVENTURERS
+		return !ignored && filepath.Ext(fn) == DESIRED_EXT
+	}
+	var paths []string
+	for _, fn := range filenames {
+		if parsable(fn) {
+			paths = append(paths, filepath.Join(dir, fn))
+		}
+	}
+	start := time.Now()
+	hits, misses := cacheHits.Load(), cacheMisses.Load()
+
+	// Process all files, in order, as soon as they are parsed
+	next := 0
+	skipIgnored := func() {
+		for next < len(filenames) && !parsable(filenames[next]) {
+			fmt.Fprintf(progress, "Processing file %s... ignored\n", filenames[next])
+			next++
+		}
+	}
+	parseFiles(paths, func(p parsed, err error) {
+		skipIgnored()
+		fmt.Fprintf(progress, "Processing file %s... ", filenames[next])
+		next++
+		p = collect(p, err)
+		io.WriteString(out, p.output.String())
+		bookModel.Sections = append(bookModel.Sections, p.sections...)
+		fmt.Fprintln(progress, "done!")
+	})
+	skipIgnored()
+
+	fmt.Fprintf(progress, "--- DONE: %d files in %s, %d at a time", len(paths), time.Since(start).Round(time.Millisecond), max(*jobs, 1))
+	if *cacheDir != "" {
+		fmt.Fprintf(progress, ", %d from the cache and %d converted", cacheHits.Load() - hits, cacheMisses.Load() - misses)
+	}
+	fmt.Fprint(progress, " ---\n\n")
+	return
+}
+
 func copyFromRoot(filename string) {
 	_, err := os.Stat(filename)
 	if err != nil {
@@ -402,32 +573,24 @@ func existDir(names ...string) bool {
 	return true
 }
 
-func load(path string, content *string, before bool) {
-	file, err := os.Open(path)
+func load(path string) string {
+	raw, err := os.ReadFile(path)
 	check(err)
-	defer file.Close()
-	raw, err := io.ReadAll(file)
-	if before {
-		*content = string(raw) + *content
-	} else {
-		*content += string(raw)
-	}
+	return string(raw)
+}
+
+// loadFilled writes a page that an imported adventurer fills in.
+func loadFilled(path string, out io.Writer, fill func(string) string) {
+	io.WriteString(out, fill(load(path)))
 }
 
 func check(err error) {
 	if err != nil {
 		fmt.Println(err)
-		os.Exit(generateCode(err))
+		os.Exit(EXIT_FAILURE)
 	}
 }
 
-func generateCode(err error) (code int) {
-	for i := 0; i < 10 && i < len(fmt.Sprint(err)); i++ {
-		code += int(fmt.Sprint(err)[i])
-	}
-	return
-}
-
 const FIRST_SECTION = "New.xml"
 const A = -1
 const B = 1
@@ -490,15 +653,27 @@ Buffer contents: [name: %s, attr: %s, value: %s]
 Stack contents: %s
 Last few characters: %s`
 )
-func parse(filename string) (output string, err error) {
+func parse(filename string) (p parsed, err error) {
+	p.filename, p.output = filename, new(strings.Builder)
 	var stack stack
+	// Text is added to its element a run at a time, rather than a character at a time
+	var text strings.Builder
+	flushText := func() {
+		if text.Len() > 0 {
+			stack.extendContent(text.String(), &p)
+			text.Reset()
+		}
+	}
+	defer flushText()
 	var mode byte
 	var name, attr, value string
-	var byteCount, lineCount int
+	var byteCount int
+	lineCount := 1
 	var history []rune
 	var depth int
 	file, errOpen := os.Open(filename)
 	check(errOpen)
+	defer file.Close()
 	scanner := bufio.NewScanner(file)
 	scanner.Split(bufio.ScanRunes)
 	for scanner.Scan() {
@@ -514,7 +689,10 @@ func parse(filename string) (output string, err error) {
 		if len(history) > HISTORY_LENGTH {
 			history = history[1:]
 		}
-		position := fmt.Sprintf(POSITION, byteCount, lineCount, mode, name, attr, value, stack, string(history))
+		// Only printed with errors
+		position := func() string {
+			return fmt.Sprintf(POSITION, byteCount, lineCount, mode, name, attr, value, stack, string(history))
+		}
 
 		// Operate this iteration
 		switch mode {
@@ -526,7 +704,7 @@ func parse(filename string) (output string, err error) {
 						depth = 1
 						mode = SKIPPING_ELEMENT
 					case ">":
-						err = errors.New(fmt.Sprintf("Element was opened and closed immediately %s", position))
+						err = parseError(filename, lineCount, "Element was opened and closed immediately", position(), stack)
 						return
 					case "/":
 						name = ""
@@ -555,10 +733,10 @@ func parse(filename string) (output string, err error) {
 						continue
 					case ">":
 						if name != stack.Name() {
-							err = errors.New(fmt.Sprintf("Tried to close an element that couldn't be closed %s", position))
+							err = parseError(filename, lineCount, "Tried to close an element that couldn't be closed", position(), stack)
 							return
 						} else {
-							stack.popElement(&output)
+							stack.popElement(&p)
 							name = ""
 							mode = READING_CONTENT
 						}
@@ -601,9 +779,10 @@ func parse(filename string) (output string, err error) {
 			case READING_CONTENT:
 				switch c {
 					case "<":
+						flushText()
 						mode = EXPECTING_ELEMENT
 					default:
-						stack.extendContent(c, &output)
+						text.WriteString(c)
 				}
 			case SKIPPING_ELEMENT:
 				switch c {
@@ -647,23 +826,30 @@ func (s *stack)addAttribute(key, value string) {
 }
 
 const SECTION = "section"
-func (s *stack)popElement(output *string) {
+func (s *stack)popElement(p *parsed) {
+	// The node is built before replace() gets its hands on the attributes
+	node := newNode((*s)[len(*s)-1])
+	p.section = stackSection(*s)
+	(*s)[len(*s)-1].file = p
 	processedElement := replace((*s)[len(*s)-1])
 	name := (*s)[len(*s)-1].Name
 	*s = (*s)[0:len(*s)-1]
 	switch {
 		case name == SECTION:
-			*output += processedElement
+			p.output.WriteString(processedElement)
+			p.sections = append(p.sections, newSection(node))
 		case len(*s) > 0:
 			(*s)[len(*s)-1].Content += processedElement
+			(*s)[len(*s)-1].Children = append((*s)[len(*s)-1].Children, node)
 	}
 }
 
-func (s *stack)extendContent(c string, output *string) {
+func (s *stack)extendContent(c string, p *parsed) {
 	if len(*s) > 0 {
 		(*s)[len(*s)-1].Content += c
+		(*s)[len(*s)-1].addText(c)
 	} else {
-		*output += c
+		p.output.WriteString(c)
 	}
 }
 
@@ -678,11 +864,12 @@ func (s *stack)Name() string {
 func (e element) String() (output string) {
 	output += "\n<"
 	output += e.Name
-	for attribute, value := range e.Attributes {
+	// Attributes are sorted, so that the output is the same on every run
+	for _, attribute := range sortedKeys(e.Attributes) {
 		output += " "
 		output += attribute
 		output += "=\""
-		output += value
+		output += e.Attributes[attribute]
 		output += "\""
 	}
 	output += ">\n\t"
@@ -693,9 +880,19 @@ func (e element) String() (output string) {
 	return
 }
 
+// sortedKeys lists the keys of a map in alphabetical order.
+func sortedKeys[V any](m map[string]V) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return
+}
+
 // THE GREAT REPLACING GALORE
 
 const TICKBOX = "◻"
+const TICKED = "☑"
 
 const FMT_SECTION =
 `
@@ -709,8 +906,20 @@ const FMT_SECTION =
 const FMT_LINK =
 `<a href="#%s">%s</a>`
 
+// Plain wording, shared by every output format
+const (
+	TXT_TURNTO = "► Turn to %s"
+	TXT_ODDS = " (%s)"
+	TXT_RANKCHECK = " and try to do lower than your Rank"
+	TXT_TICK_BOX = "✓ Tick the box"
+	TXT_TICK_CODEWORD = "✓ Tick the codeword "
+	TXT_REROLL = "■ Reroll"
+	TXT_RETURN = "► Go back to the section you came from."
+	TXT_RESURRECTION = "Resurrection of %s: Book %s, Section %s (%s)"
+)
+
 const FMT_TURNTO =
-`<span class="turn-to">► Turn to %s</span>`
+`<span class="turn-to">` + TXT_TURNTO + `</span>`
 
 const FMT_SHOPITEM =
 `<tr class="shop-item">
@@ -737,7 +946,7 @@ const FMT_TABLE =
 </table>`
 
 const FMT_RESURRECTION =
-`<span class="resurrection">Resurrection of %s: Book %s, Section %s (%s)</span>`
+`<span class="resurrection">` + TXT_RESURRECTION + `</span>`
 
 const FMT_ROLL =
 `■ Roll %s dice`
@@ -745,9 +954,19 @@ const FMT_ROLL =
 const FMT_CHECK =
 `■ Make a %s check against a difficulty of %s`
 
+// Only used by -interactive, so that the script can put a dice roller next to rolls and checks
+const FMT_ODDS =	// checkOdds
+`<span class="odds">` + TXT_ODDS + `</span>`
+
+const FMT_DICE =	// dice, ability, level, rank check, content
+`<span class="dice" data-dice="%s" data-ability="%s" data-level="%s" data-rank="%s">%s</span>`
+
 const FMT_ITEM =
 `<span class="item">%s</span>`
 
+const FMT_CONDITION =	// condition
+`<span class="condition">%s</span>`
+
 const FMT_IMAGE =
 `<img class="attachment" src="%s"></img>`
 
@@ -763,6 +982,9 @@ const FMT_FIGHT =
 </tr>
 </table>`
 
+const FMT_FIGHT_ODDS =	// fightOddsText
+`<span class="odds fight-odds">%s</span>`
+
 const FMT_CACHE =
 `<h4>%s</h4>
 <div class="cache"></div>
@@ -779,9 +1001,15 @@ type Goto struct {
 }
 
 func replace(e element) (out string) {
-	// Remove hidden tags
+	// Remove hidden tags, unless they are revealed
 	if e.Attributes["hidden"] == "t" {
-		return
+		if !*reveal {
+			return
+		}
+		shown := e
+		shown.Attributes = maps.Clone(e.Attributes)
+		delete(shown.Attributes, "hidden")
+		return revealed(e, replace(shown))
 	}
 
 	switch e.Name {
@@ -792,17 +1020,13 @@ func replace(e element) (out string) {
 			var id string
 			var boxCount int
 			var ok bool
+			id = sectionID(e)
 			if _, ok = e.Attributes["boxes"]; ok {
 				boxCount, _ = strconv.Atoi(e.Attributes["boxes"])
-				for i := 0; i < boxCount; i++ {
-					tickboxes += " " + TICKBOX
-				}
+				tickboxes = sectionBoxes(id, boxCount)
 			}
 			if profession, ok := e.Attributes["profession"]; ok {
 				e.Content = (printStats(profession) + e.Content)
-				id = strconv.Itoa(book) + "-" + strings.Fields(e.Attributes["name"])[0]
-			} else {
-				id = strconv.Itoa(book) + "-" + e.Attributes["name"]
 			}
 
 			out = fmt.Sprintf(FMT_SECTION, menu(), id, e.Attributes["name"], tickboxes, e.Content)
@@ -815,63 +1039,13 @@ func replace(e element) (out string) {
 		// Then we'll decide whether to display it as a shop item or a pickup
 		case "weapon", "armour", "item", "tool", "ship", "cargo", "buy", "sell", "trade", "gain", "lose":
 			var name string
-			classItem := []string{"weapon", "armour", "item", "tool", "ship", "cargo", "stamina", "rank", "ability", "title"}
 			// If the tag has content, that content will always override anything else.
 			if strings.TrimSpace(e.Content) != "" {
 				name = e.Content
 			} else {
-				// Otherwise, we must first find the base name (before any modifiers).
-				// Let's check if there is a name attribute (the most straightforward way.)
-				name, _ = e.Attributes["name"]
-				// Some tags, especially 'trade' tags, instead have the name inside an attribute called like its type
-				// Also, the 'crew' attribute exists but, when displaying the item, it is always bypassed in favor of its price in 'shards'.
-				// So in the 'buy' and 'sell' tags, every item displays its name, EXCEPT for crews, which display the price
-				// There is no logic in this
-				if name == "" {
-					for k, v := range e.Attributes {
-						if slices.Contains(classItem, k) {
-							name = v
-							break
-						}
-					}
-					// Crews display the shard value so maybe
-					if name == "" && e.Attributes["shards"] != "" {
-						name = e.Attributes["shards"] + " shards"
-					} else if name == "" {
-						// Some rare cases do not have a name at all, and instead inherit it from their tag name.
-						// It is weird, I know, but some items in this game are generic so that you can flavour them as you like, especially weapons.
-						name = e.Name
-					}
-				}
-				name = capitalize(name)
-
-				// Now that we have found the base name, we must attach any properties it may have
-				var properties string
-				// Ships have a 'capacity' value that is not specified in tags because the game's internal logic keeps track of it
-				switch name {
-					case "Barque":
-						properties += "capacity: 1, "
-					case "Brigantine":
-						properties += "capacity: 2, "
-					case "Galleon":
-						properties += "capacity: 3, "
-				}
-				if _, ok := e.Attributes["initialCrew"]; ok {
-					properties += "initial crew: " + e.Attributes["initialCrew"] + ", "
-				}
-				if _, ok := e.Attributes["bonus"]; ok {
-					properties += "+" + e.Attributes["bonus"]
-				}
-				if _, ok := e.Attributes["ability"]; ok {
-					properties += " to " + e.Attributes["ability"]
-				}
-				if properties != "" {
-					properties = strings.TrimSuffix(properties, ", ")
-					name += " (" + properties + ")"
-				}
 				// Let us put the freshly baked item into a span with class 'item'
 				// This is important for formatting, as the books display items in a different font
-				name = fmt.Sprintf(FMT_ITEM, name)
+				name = fmt.Sprintf(FMT_ITEM, itemName(e))
 			}
 
 
@@ -957,6 +1131,9 @@ func replace(e element) (out string) {
 
 		case "fight":
 			out = fmt.Sprintf(FMT_FIGHT, e.Attributes["name"], e.Attributes["combat"], e.Attributes["defence"], e.Attributes["stamina"])
+			if f, professions := newNode(e).Fight, startingProfessions(); *combat && fightHasOdds(professions, *f) {
+				out += fmt.Sprintf(FMT_FIGHT_ODDS, fightOddsText(professions, *f))
+			}
 
 		case "resurrection":
 			if strings.TrimSpace(e.Content) == "" {
@@ -992,16 +1169,22 @@ func replace(e element) (out string) {
 			} else {
 				out = e.Content
 			}
+			if *interactive {
+				out = fmt.Sprintf(FMT_DICE, e.Attributes["dice"], "", "", "", out)
+			}
 
 		case "rankcheck":
 			if strings.TrimSpace(e.Content) == "" {
 				if e.Attributes["dice"] == "" {
 					e.Attributes["dice"] = "2"
 				}
-				out = fmt.Sprintf(FMT_ROLL, e.Attributes["dice"]) + " and try to do lower than your Rank"
+				out = fmt.Sprintf(FMT_ROLL, e.Attributes["dice"]) + TXT_RANKCHECK
 			} else {
 				out = e.Content
 			}
+			if *interactive {
+				out = fmt.Sprintf(FMT_DICE, e.Attributes["dice"], "", "", "t", out)
+			}
 
 		case "difficulty":
 			if strings.TrimSpace(e.Content) == "" {
@@ -1009,13 +1192,21 @@ func replace(e element) (out string) {
 			} else {
 				out = e.Content
 			}
+			// The rules are parsed before any book, so they have no professions to give the odds of
+			if professions := startingProfessions(); *odds && len(professions) > 0 {
+				level, _ := strconv.Atoi(e.Attributes["level"])
+				out += fmt.Sprintf(FMT_ODDS, checkOdds(professions, e.Attributes["ability"], level))
+			}
+			if *interactive {
+				out = fmt.Sprintf(FMT_DICE, "2", e.Attributes["ability"], e.Attributes["level"], "", out)
+			}
 
 		case "tick":
 			if strings.TrimSpace(e.Content) == "" {
 				if e.Attributes["codeword"] == "" {
-					out = "✓ Tick the box"
+					out = TXT_TICK_BOX
 				} else {
-					out = "✓ Tick the codeword " + fmt.Sprintf(FMT_ITEM, e.Attributes["codeword"])
+					out = TXT_TICK_CODEWORD + fmt.Sprintf(FMT_ITEM, e.Attributes["codeword"])
 				}
 			} else {
 				out = e.Content
@@ -1023,6 +1214,9 @@ func replace(e element) (out string) {
 
 		case "if":
 			out = strings.TrimSpace(e.Content)
+			if c := condition(e.Attributes, e.Content, htmlStyle); c != "" && out != "" {
+				out = fmt.Sprintf(FMT_CONDITION, c) + " " + out
+			}
 
 		case "disease":
 			if strings.TrimSpace(e.Content) == "" {
@@ -1033,14 +1227,14 @@ func replace(e element) (out string) {
 
 		case "reroll":
 			if e.Content == "" {
-				out = "■ Reroll"
+				out = TXT_REROLL
 			} else {
 				out = e.Content
 			}
 
 		case "return":
 			if e.Content == "" {
-				out = "► Go back to the section you came from."
+				out = TXT_RETURN
 			} else {
 				out = e.Content
 			}
@@ -1073,6 +1267,10 @@ func replace(e element) (out string) {
 
 		case "desc", "adjust", "effect":
 			// These tags are straight up deleted because they are not rendered in the game
+			// Unless they are revealed for proofreading
+			if *reveal {
+				out = revealed(e, e.Content)
+			}
 			return
 
 		// ------------------------------------------------------------------------
@@ -1080,8 +1278,13 @@ func replace(e element) (out string) {
 		// IGNORED TAGS -----------------------------------------------------------
 
 		default:
-			// Unspecified tags are left as they are
-			out = e.String()
+			// Unspecified tags are left as they are, unless the mapping file tells otherwise
+			if rule, ok := tagRules[e.Name]; ok {
+				out = rule.render(e)
+			} else {
+				e.file.noteUnknownTag(e.Name)
+				out = e.String()
+			}
 
 		// ------------------------------------------------------------------------
 	}
@@ -1103,6 +1306,69 @@ func replace(e element) (out string) {
 	return
 }
 
+// sectionID returns the anchor used for a section tag in the current book.
+func sectionID(e element) string {
+	if _, ok := e.Attributes["profession"]; ok {
+		return strconv.Itoa(book) + "-" + strings.Fields(e.Attributes["name"])[0]
+	}
+	return strconv.Itoa(book) + "-" + e.Attributes["name"]
+}
+
+// itemBaseName finds the name of an item tag before any modifiers are attached.
+func itemBaseName(e element) (name string) {
+	classItem := []string{"weapon", "armour", "item", "tool", "ship", "cargo", "stamina", "rank", "ability", "title"}
+	// Let's check if there is a name attribute (the most straightforward way.)
+	name, _ = e.Attributes["name"]
+	// Some tags, especially 'trade' tags, instead have the name inside an attribute called like its type
+	// Also, the 'crew' attribute exists but, when displaying the item, it is always bypassed in favor of its price in 'shards'.
+	// So in the 'buy' and 'sell' tags, every item displays its name, EXCEPT for crews, which display the price
+	// There is no logic in this
+	if name == "" {
+		// The types are looked up in the order of classItem, so that a tag with several of them always gets the same name
+		for _, k := range classItem {
+			if v, ok := e.Attributes[k]; ok {
+				name = v
+				break
+			}
+		}
+		// Crews display the shard value so maybe
+		if name == "" && e.Attributes["shards"] != "" {
+			name = e.Attributes["shards"] + " shards"
+		} else if name == "" {
+			// Some rare cases do not have a name at all, and instead inherit it from their tag name.
+			// It is weird, I know, but some items in this game are generic so that you can flavour them as you like, especially weapons.
+			name = e.Name
+		}
+	}
+	return capitalize(name)
+}
+
+// itemName assembles the full display name of an item tag, properties included.
+func itemName(e element) (name string) {
+	name = itemBaseName(e)
+
+	// Now that we have found the base name, we must attach any properties it may have
+	var properties string
+	// Ships have a 'capacity' value that is not specified in tags because the game's internal logic keeps track of it
+	if capacity, ok := SHIP_CAPACITY[name]; ok {
+		properties += "capacity: " + strconv.Itoa(capacity) + ", "
+	}
+	if _, ok := e.Attributes["initialCrew"]; ok {
+		properties += "initial crew: " + e.Attributes["initialCrew"] + ", "
+	}
+	if _, ok := e.Attributes["bonus"]; ok {
+		properties += "+" + e.Attributes["bonus"]
+	}
+	if _, ok := e.Attributes["ability"]; ok {
+		properties += " to " + e.Attributes["ability"]
+	}
+	if properties != "" {
+		properties = strings.TrimSuffix(properties, ", ")
+		name += " (" + properties + ")"
+	}
+	return
+}
+
 // --- ADVENTURERS MANAGEMENT ---
 
 type AdventurersRaw struct {
@@ -1133,6 +1399,7 @@ type ItemRaw struct {
 	Profession string `xml:"profession,attr"`
 	Name string `xml:"name,attr"`
 	Bonus string `xml:"bonus,attr"`
+	Ability string `xml:"ability,attr"`
 }
 
 type ProfessionRaw struct {
@@ -1142,20 +1409,21 @@ type ProfessionRaw struct {
 }
 
 type Profession struct {
-	Name string
-	PersonName string
-	Description string
-	Rank string
-	Stamina string
-	Gold string
-	Abilities []string
-	Equipment []Item
+	Name string `json:"name"`
+	PersonName string `json:"personName"`
+	Description string `json:"description"`
+	Rank string `json:"rank"`
+	Stamina string `json:"stamina"`
+	Gold string `json:"gold"`
+	Abilities []string `json:"abilities"`
+	Equipment []Item `json:"equipment"`
 }
 
 type Item struct {
-	Name string
-	Type string
-	Bonus string
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Bonus string `json:"bonus,omitempty"`
+	Ability string `json:"ability,omitempty"`
 }
 
 var Starting map[string]Profession
@@ -1193,6 +1461,7 @@ func updateStats(fn string) () {
 				var item Item
 				item.Name = e.Name
 				item.Bonus = e.Bonus
+				item.Ability = e.Ability
 				item.Type = e.XMLName.Local
 				profession.Equipment = append(profession.Equipment, item)
 			}
@@ -1203,6 +1472,17 @@ func updateStats(fn string) () {
 	return
 }
 
+// startingProfessions lists the professions loaded by updateStats(), sorted by name.
+func startingProfessions() (professions []Profession) {
+	for _, p := range Starting {
+		professions = append(professions, p)
+	}
+	slices.SortFunc(professions, func(a, b Profession) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return
+}
+
 /* CLASSES:
  * stats-sheet
  * 	stats-abilities-header
@@ -1223,6 +1503,8 @@ func updateStats(fn string) () {
  * 		equipment-item-type
  * 		equipment-item-name
  */
+var ABILITIES = []string{"Charisma", "Combat", "Magic", "Sanctity", "Scouting", "Thievery"}
+
 const STATS_FORMAT =	// p.Name, p.Abilities..., p.Stamina, p.Rank, p.Gold, startingEquip
 `<h3 class="profession">
 %s
@@ -1264,6 +1546,14 @@ const STARTING_EQUIP_FORMAT =
 <th colspan="2">%s</th>
 <td class="item" colspan="4">%s</td>
 </tr>`
+// equipmentName is the display name of a piece of starting equipment.
+func equipmentName(e Item) string {
+	if e.Bonus != "" {
+		return capitalize(e.Name) + " (+" + e.Bonus + ")"
+	}
+	return capitalize(e.Name)
+}
+
 func printStats(name string) string {
 	var p Profession
 	var startingEquip string
@@ -1271,22 +1561,15 @@ func printStats(name string) string {
 	p, ok = Starting[name]
 	if !ok {
 		fmt.Printf("Found no match for %s!\nThese are the starting professions registered from Adventurers.xml:\n", name)
-		for k := range Starting {
+		for _, k := range sortedKeys(Starting) {
 			fmt.Println(k)
 		}
 		fmt.Println("Aborting.")
-		os.Exit(666)
+		os.Exit(EXIT_FAILURE)
 	}
 	cha, com, mag, san, sco, thi := p.Abilities[0], p.Abilities[1], p.Abilities[2], p.Abilities[3], p.Abilities[4], p.Abilities[5]
 	for _, e := range p.Equipment {
-		var nameFull string
-		e.Name, e.Type = capitalize(e.Name), capitalize(e.Type)
-		if e.Bonus != "" {
-			nameFull = e.Name + " (+" + e.Bonus + ")"
-		} else {
-			nameFull = e.Name
-		}
-		startingEquip += fmt.Sprintf(STARTING_EQUIP_FORMAT, e.Type, nameFull)
+		startingEquip += fmt.Sprintf(STARTING_EQUIP_FORMAT, capitalize(e.Type), equipmentName(e))
 	}
 	return fmt.Sprintf(STATS_FORMAT, p.Name, cha, com, mag, san, sco, thi, p.Stamina, p.Rank, p.Gold, startingEquip)
 }
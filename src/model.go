@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// --- BOOK MODEL ---
+// While parse() renders HTML, it also builds a typed tree of every section it reads.
+// The tree is what gets exported with -format json, and what the reports are computed from.
+// The schema is documented in docs/model.md: bump MODEL_VERSION whenever it changes.
+
+const MODEL_VERSION = 1
+const MODEL_SCHEMA = "jafl-to-html/model"
+
+// Node types
+const (
+	NODE_TEXT = "text"
+	NODE_PARAGRAPH = "paragraph"
+	NODE_ITEM = "item"
+	NODE_MARKET = "market"
+	NODE_CHOICES = "choices"
+	NODE_CHOICE = "choice"
+	NODE_OUTCOMES = "outcomes"
+	NODE_OUTCOME = "outcome"
+	NODE_GOTO = "goto"
+	NODE_FIGHT = "fight"
+	NODE_TICK = "tick"
+	NODE_RESURRECTION = "resurrection"
+	NODE_ROLL = "roll"
+	NODE_CHECK = "check"
+	NODE_CONDITION = "condition"
+	NODE_IMAGE = "image"
+	NODE_HEADER = "header"
+	NODE_RETURN = "return"
+	NODE_REROLL = "reroll"
+	NODE_DISEASE = "disease"
+	NODE_CACHE = "cache"
+	NODE_GROUP = "group"
+	NODE_NOTE = "note"
+	NODE_ELEMENT = "element"
+)
+
+type Document struct {
+	Schema string `json:"schema"`
+	Version int `json:"version"`
+	Rules []Section `json:"rules,omitempty"`
+	Books []Book `json:"books"`
+}
+
+type Book struct {
+	Number int `json:"number"`
+	Title string `json:"title"`
+	Region string `json:"region"`
+	Dir string `json:"dir"`
+	Map string `json:"map,omitempty"`
+	Professions []Profession `json:"professions,omitempty"`
+	Sections []Section `json:"sections"`
+}
+
+type Section struct {
+	ID string `json:"id"`
+	Book int `json:"book"`
+	Name string `json:"name"`
+	Boxes int `json:"boxes,omitempty"`
+	Profession string `json:"profession,omitempty"`
+	Content []Node `json:"content,omitempty"`
+}
+
+type Node struct {
+	Type string `json:"type"`
+	Tag string `json:"tag,omitempty"`
+	Text string `json:"text,omitempty"`
+	Hidden bool `json:"hidden,omitempty"`
+	Item *Item `json:"item,omitempty"`
+	Price *Price `json:"price,omitempty"`
+	Fight *Fight `json:"fight,omitempty"`
+	Check *Check `json:"check,omitempty"`
+	Target *Target `json:"target,omitempty"`
+	Range string `json:"range,omitempty"`
+	Codeword string `json:"codeword,omitempty"`
+	Resurrection *Resurrection `json:"resurrection,omitempty"`
+	File string `json:"file,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Children []Node `json:"children,omitempty"`
+}
+
+type Price struct {
+	Buy string `json:"buy,omitempty"`
+	Sell string `json:"sell,omitempty"`
+}
+
+type Fight struct {
+	Name string `json:"name"`
+	Combat int `json:"combat"`
+	Defence int `json:"defence"`
+	Stamina int `json:"stamina"`
+}
+
+type Check struct {
+	Ability string `json:"ability,omitempty"`
+	Level int `json:"level,omitempty"`
+	Dice int `json:"dice,omitempty"`
+}
+
+type Target struct {
+	Book int `json:"book"`
+	Section string `json:"section"`
+	ID string `json:"id"`
+}
+
+type Resurrection struct {
+	God string `json:"god"`
+	Book int `json:"book"`
+	Section string `json:"section"`
+	Text string `json:"text,omitempty"`
+}
+
+func (e *element)addText(c string) {
+	last := len(e.Children) - 1
+	if last >= 0 && e.Children[last].Type == NODE_TEXT {
+		e.Children[last].Text += c
+	} else {
+		e.Children = append(e.Children, Node{Type: NODE_TEXT, Text: c})
+	}
+}
+
+func newNode(e element) (n Node) {
+	n.Tag = e.Name
+	n.Hidden = e.Attributes["hidden"] == "t"
+	n.Children = normalizeText(e.Children)
+	// The model holds plain text: the entities of the XML are unescaped once, here
+	if len(e.Attributes) > 0 {
+		unescaped := make(map[string]string)
+		for k, v := range e.Attributes {
+			unescaped[k] = html.UnescapeString(v)
+		}
+		e.Attributes = unescaped
+		n.Attributes = maps.Clone(unescaped)
+	}
+
+	switch e.Name {
+		case "p":
+			n.Type = NODE_PARAGRAPH
+
+		case "weapon", "armour", "item", "tool", "ship", "cargo", "buy", "sell", "trade", "gain", "lose":
+			n.Type = NODE_ITEM
+			n.Item = &Item{
+				Name: itemBaseName(e),
+				Type: itemType(e),
+				Bonus: e.Attributes["bonus"],
+				Ability: e.Attributes["ability"],
+			}
+			if strings.TrimSpace(e.Content) != "" {
+				n.Text = plainText(n.Children)
+			}
+			buy, ok1 := e.Attributes["buy"]
+			sell, ok2 := e.Attributes["sell"]
+			if ok1 || ok2 {
+				n.Price = &Price{Buy: buy, Sell: sell}
+			}
+
+		case "market":
+			n.Type = NODE_MARKET
+
+		case "choices":
+			n.Type = NODE_CHOICES
+
+		case "outcomes":
+			n.Type = NODE_OUTCOMES
+
+		case "choice", "success", "failure":
+			n.Type = NODE_CHOICE
+
+		case "outcome":
+			n.Type = NODE_OUTCOME
+			n.Range = e.Attributes["range"]
+
+		case "goto":
+			n.Type = NODE_GOTO
+
+		case "fight":
+			n.Type = NODE_FIGHT
+			n.Fight = &Fight{
+				Name: e.Attributes["name"],
+				Combat: atoi(e.Attributes["combat"]),
+				Defence: atoi(e.Attributes["defence"]),
+				Stamina: atoi(e.Attributes["stamina"]),
+			}
+
+		case "tick":
+			n.Type = NODE_TICK
+			n.Codeword = e.Attributes["codeword"]
+
+		case "resurrection":
+			n.Type = NODE_RESURRECTION
+			n.Resurrection = &Resurrection{
+				God: e.Attributes["god"],
+				Book: atoi(e.Attributes["book"]),
+				Section: e.Attributes["section"],
+				Text: e.Attributes["text"],
+			}
+			// The section attribute belongs to the temple, not to a link
+			delete(n.Attributes, "section")
+
+		case "random", "training", "rankcheck":
+			n.Type = NODE_ROLL
+			n.Check = &Check{Dice: atoi(e.Attributes["dice"])}
+			if n.Check.Dice == 0 {
+				n.Check.Dice = 2
+			}
+
+		case "difficulty":
+			n.Type = NODE_CHECK
+			n.Check = &Check{Ability: e.Attributes["ability"], Level: atoi(e.Attributes["level"])}
+
+		case "if":
+			n.Type = NODE_CONDITION
+
+		case "image":
+			n.Type = NODE_IMAGE
+			n.File = e.Attributes["file"]
+
+		case "header":
+			n.Type = NODE_HEADER
+			n.Text = capitalize(e.Attributes["type"])
+
+		case "return":
+			n.Type = NODE_RETURN
+
+		case "reroll":
+			n.Type = NODE_REROLL
+
+		case "disease":
+			n.Type = NODE_DISEASE
+			n.Text = e.Attributes["name"]
+
+		case "itemcache", "moneycache":
+			n.Type = NODE_CACHE
+			n.Text = e.Attributes["text"]
+
+		case "group":
+			n.Type = NODE_GROUP
+
+		case "desc", "adjust", "effect":
+			n.Type = NODE_NOTE
+
+		default:
+			n.Type = NODE_ELEMENT
+	}
+
+	// Tables only hold rows, so the spaces between them mean nothing
+	switch n.Type {
+		case NODE_MARKET, NODE_CHOICES, NODE_OUTCOMES:
+			var rows []Node
+			for _, c := range n.Children {
+				if c.Type != NODE_TEXT || strings.TrimSpace(c.Text) != "" {
+					rows = append(rows, c)
+				}
+			}
+			n.Children = rows
+	}
+
+	// Anything with a section attribute points somewhere
+	if sc := e.Attributes["section"]; sc != "" && n.Type != NODE_RESURRECTION {
+		n.Target = newTarget(e.Attributes["book"], sc)
+	} else if n.Type == NODE_GROUP {
+		for _, c := range n.Children {
+			if c.Type == NODE_GOTO && c.Target != nil {
+				n.Target = c.Target
+			}
+		}
+	}
+	return
+}
+
+func newSection(n Node) (s Section) {
+	s.Book = book
+	s.Name = n.Attributes["name"]
+	s.Boxes = atoi(n.Attributes["boxes"])
+	s.Profession = n.Attributes["profession"]
+	s.ID = sectionID(element{Attributes: n.Attributes})
+	s.Content = n.Children
+	return
+}
+
+func newTarget(bk, sc string) *Target {
+	if bk == "" {
+		bk = strconv.Itoa(book)
+	}
+	return &Target{Book: atoi(bk), Section: sc, ID: bk + "-" + sc}
+}
+
+// itemType tells what kind of item a tag holds, for tags like 'buy' that do not say it in their name.
+func itemType(e element) string {
+	switch e.Name {
+		case "weapon", "armour", "item", "tool", "ship", "cargo":
+			return e.Name
+	}
+	for _, k := range []string{"weapon", "armour", "item", "tool", "ship", "cargo", "stamina", "rank", "ability", "title"} {
+		if _, ok := e.Attributes[k]; ok {
+			return k
+		}
+	}
+	if _, ok := e.Attributes["shards"]; ok {
+		return "shards"
+	}
+	return "item"
+}
+
+// normalizeText collapses the whitespace of text nodes.
+// Text that only holds whitespace is dropped, unless it separates two inline nodes.
+func normalizeText(in []Node) (out []Node) {
+	for i, n := range in {
+		if n.Type == NODE_TEXT {
+			text := html.UnescapeString(strings.Join(strings.Fields(strings.ReplaceAll(n.Text, "{box} (if box ticked)", TICKBOX)), " "))
+			if text == "" {
+				if i > 0 && i < len(in)-1 && isInline(in[i-1]) && isInline(in[i+1]) {
+					out = append(out, Node{Type: NODE_TEXT, Text: " "})
+				}
+				continue
+			}
+			if unicode.IsSpace([]rune(n.Text)[0]) {
+				text = " " + text
+			}
+			if r := []rune(n.Text); unicode.IsSpace(r[len(r)-1]) {
+				text += " "
+			}
+			n.Text = text
+		}
+		out = append(out, n)
+	}
+	return
+}
+
+// isInline tells whether a node flows within the text of a paragraph.
+func isInline(n Node) bool {
+	switch n.Type {
+		case NODE_PARAGRAPH, NODE_MARKET, NODE_CHOICES, NODE_OUTCOMES, NODE_FIGHT, NODE_IMAGE, NODE_CACHE, NODE_HEADER, NODE_NOTE:
+			return false
+	}
+	return true
+}
+
+// hasBlocks tells whether any of the nodes must be laid out outside a paragraph.
+func hasBlocks(nodes []Node) bool {
+	for _, n := range nodes {
+		if !isInline(n) || hasBlocks(n.Children) {
+			return true
+		}
+	}
+	return false
+}
+
+// plainText flattens a list of nodes into the words they contain.
+func plainText(nodes []Node) (out string) {
+	for _, n := range nodes {
+		if n.Type == NODE_TEXT || len(n.Children) == 0 {
+			out += n.Text
+		}
+		out += plainText(n.Children)
+	}
+	return strings.Join(strings.Fields(out), " ")
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(strings.TrimSpace(s))
+	return n
+}
+
+func writeJSON(filename string, v any) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// --- ODDS ---
+// With -odds, every difficulty check tells the chance of success of each starting profession.
+// A check rolls two dice and adds the ability, and succeeds when the total beats the difficulty.
+// The 'Hardest checks' report lists, for each book, the checks with the lowest average chance.
+
+const ODDS_HARDEST = 10
+
+// successChance is the chance of beating a difficulty with two dice and a score.
+func successChance(score, level int) float64 {
+	wins := 0
+	for i := 1; i <= 6; i++ {
+		for j := 1; j <= 6; j++ {
+			if i + j + score > level {
+				wins++
+			}
+		}
+	}
+	return float64(wins) / 36
+}
+
+func percent(p float64) string {
+	return fmt.Sprintf("%.0f%%", p * 100)
+}
+
+// checkChances gives the chance of success of every profession, in the order of the list.
+func checkChances(professions []Profession, ability string, level int) (chances []float64) {
+	for _, p := range professions {
+		chances = append(chances, successChance(newAdventurer(p).Ability(ability), level))
+	}
+	return
+}
+
+// checkOdds is the annotation of a check, like "Priest 58%, Warrior 17%", for the starting professions of a book.
+func checkOdds(professions []Profession, ability string, level int) string {
+	var odds []string
+	for i, c := range checkChances(professions, ability, level) {
+		odds = append(odds, professions[i].Name + " " + percent(c))
+	}
+	return strings.Join(odds, ", ")
+}
+
+type hardCheck struct {
+	section Section
+	check Check
+	chances []float64
+	average float64
+}
+
+// hardestChecks lists the checks of each book that the starting professions are least likely to pass.
+func hardestChecks(document Document) (r Report) {
+	r.ID = "hardest-checks"
+	r.Title = "Hardest checks"
+	r.Intro = fmt.Sprintf("The %d checks of each book with the lowest average chance of success for the starting professions.", ODDS_HARDEST)
+	for _, bk := range document.Books {
+		var checks []hardCheck
+		for _, s := range bk.Sections {
+			for _, c := range findNodes(s.Content, NODE_CHECK) {
+				h := hardCheck{section: s, check: *c.Check, chances: checkChances(bk.Professions, c.Check.Ability, c.Check.Level)}
+				for _, p := range h.chances {
+					h.average += p / float64(len(h.chances))
+				}
+				checks = append(checks, h)
+			}
+		}
+		if len(checks) == 0 {
+			continue
+		}
+		slices.SortStableFunc(checks, func(a, b hardCheck) int {
+			switch {
+				case a.average < b.average:
+					return -1
+				case a.average > b.average:
+					return 1
+			}
+			return 0
+		})
+		table := ReportTable{Title: bk.Title, Header: []string{"Section", "Check"}}
+		for _, p := range bk.Professions {
+			table.Header = append(table.Header, p.Name)
+		}
+		for _, h := range checks[:min(len(checks), ODDS_HARDEST)] {
+			row := []ReportCell{sectionCell(h.section), {Text: fmt.Sprintf("%s %d", capitalize(strings.ToLower(h.check.Ability)), h.check.Level)}}
+			for _, p := range h.chances {
+				row = append(row, ReportCell{Text: percent(p)})
+			}
+			table.Rows = append(table.Rows, row)
+		}
+		r.Tables = append(r.Tables, table)
+	}
+	return
+}
+
+// findNodes lists the nodes of a type, wherever they are.
+func findNodes(nodes []Node, kind string) (found []Node) {
+	for _, n := range nodes {
+		if n.Type == kind {
+			found = append(found, n)
+		}
+		found = append(found, findNodes(n.Children, kind)...)
+	}
+	return
+}
+
+// allNodes lists the nodes and every node inside them.
+func allNodes(nodes []Node) (all []Node) {
+	for _, n := range nodes {
+		all = append(all, n)
+		all = append(all, allNodes(n.Children)...)
+	}
+	return
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSuccessChance(t *testing.T) {
+	for _, c := range []struct {
+		score, level int
+		wins int	// Rolls of two dice out of 36 that beat the level
+	}{
+		{0, 1, 36},
+		{0, 2, 35},
+		{0, 6, 21},
+		{0, 7, 15},
+		{0, 11, 1},
+		{0, 12, 0},
+		{2, 9, 15},
+		{6, 4, 36},
+		{-2, 10, 0},
+	} {
+		if got, want := successChance(c.score, c.level), float64(c.wins) / 36; math.Abs(got - want) > 1e-9 {
+			t.Errorf("successChance(%d, %d) = %v, want %v", c.score, c.level, got, want)
+		}
+	}
+}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --- OPENDOCUMENT TEXT ---
+// The ODT writer renders the book model into content.xml, and maps the classes of flands.css
+// to named styles in styles.xml, so that they can be restyled from LibreOffice.
+// Sections get a bookmark named after their anchor, and links point to those bookmarks,
+// which keeps them working when the document is exported to PDF.
+
+const ODT_MIMETYPE = "application/vnd.oasis.opendocument.text"
+const ODT_PICTURES = "Pictures"
+const ODT_IMAGE_WIDTH = 16.0	// cm
+
+const ODT_NAMESPACES =
+`xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:style="urn:oasis:names:tc:opendocument:xmlns:style:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0" xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0" xmlns:draw="urn:oasis:names:tc:opendocument:xmlns:drawing:1.0" xmlns:fo="urn:oasis:names:tc:opendocument:xmlns:xsl-fo-compatible:1.0" xmlns:xlink="http://www.w3.org/1999/xlink" xmlns:svg="urn:oasis:names:tc:opendocument:xmlns:svg-compatible:1.0" office:version="1.3"`
+
+const ODT_MANIFEST =	// file entries
+`<?xml version="1.0" encoding="UTF-8"?>
+<manifest:manifest xmlns:manifest="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0" manifest:version="1.3">
+<manifest:file-entry manifest:full-path="/" manifest:version="1.3" manifest:media-type="` + ODT_MIMETYPE + `"/>
+<manifest:file-entry manifest:full-path="content.xml" manifest:media-type="text/xml"/>
+<manifest:file-entry manifest:full-path="styles.xml" manifest:media-type="text/xml"/>
+%s</manifest:manifest>
+`
+
+const ODT_MANIFEST_ENTRY =	// path, media type
+`<manifest:file-entry manifest:full-path="%s" manifest:media-type="%s"/>
+`
+
+const ODT_CONTENT =	// body
+`<?xml version="1.0" encoding="UTF-8"?>
+<office:document-content ` + ODT_NAMESPACES + `>
+<office:body>
+<office:text>
+%s</office:text>
+</office:body>
+</office:document-content>
+`
+
+// The named styles match the classes in flands.css
+const ODT_STYLES =
+`<?xml version="1.0" encoding="UTF-8"?>
+<office:document-styles ` + ODT_NAMESPACES + `>
+<office:styles>
+<style:default-style style:family="paragraph">
+<style:paragraph-properties fo:text-align="justify"/>
+<style:text-properties style:font-name="FreeSerif" fo:font-family="FreeSerif" fo:font-size="12pt"/>
+</style:default-style>
+<style:style style:name="Standard" style:family="paragraph">
+<style:paragraph-properties fo:margin-top="0.1cm" fo:margin-bottom="0.2cm"/>
+</style:style>
+<style:style style:name="title" style:display-name="title" style:family="paragraph" style:parent-style-name="Standard">
+<style:paragraph-properties fo:text-align="center" fo:break-before="page" fo:margin-top="4cm"/>
+<style:text-properties fo:font-size="30pt" fo:font-weight="bold" fo:font-variant="small-caps" style:text-underline-style="solid" style:text-underline-width="auto" style:text-underline-color="font-color"/>
+</style:style>
+<style:style style:name="section-title" style:display-name="section-title" style:family="paragraph" style:parent-style-name="Standard" style:default-outline-level="2">
+<style:paragraph-properties fo:text-align="center" fo:break-before="page" fo:margin-bottom="0.4cm"/>
+<style:text-properties fo:font-size="18pt" fo:font-weight="bold" style:text-underline-style="solid" style:text-underline-width="auto" style:text-underline-color="font-color"/>
+</style:style>
+<style:style style:name="subtitle" style:display-name="subtitle" style:family="paragraph" style:parent-style-name="Standard">
+<style:paragraph-properties fo:text-align="center"/>
+<style:text-properties fo:font-size="14pt" fo:font-weight="bold"/>
+</style:style>
+<style:style style:name="table-cell" style:display-name="table-cell" style:family="paragraph" style:parent-style-name="Standard">
+<style:paragraph-properties fo:text-align="center"/>
+</style:style>
+<style:style style:name="table-header" style:display-name="table-header" style:family="paragraph" style:parent-style-name="table-cell">
+<style:text-properties fo:font-weight="bold"/>
+</style:style>
+<style:style style:name="fight" style:display-name="fight" style:family="paragraph" style:parent-style-name="table-cell">
+<style:text-properties fo:font-weight="bold"/>
+</style:style>
+<style:style style:name="shop-item" style:display-name="shop-item" style:family="paragraph" style:parent-style-name="table-cell"/>
+<style:style style:name="codeword" style:display-name="codeword" style:family="paragraph" style:parent-style-name="Standard">
+<style:text-properties fo:font-size="16pt" fo:font-weight="bold" fo:font-variant="small-caps"/>
+</style:style>
+<style:style style:name="image" style:display-name="image" style:family="paragraph" style:parent-style-name="Standard">
+<style:paragraph-properties fo:text-align="center"/>
+</style:style>
+<style:style style:name="item" style:display-name="item" style:family="text">
+<style:text-properties fo:font-weight="bold" fo:font-variant="small-caps"/>
+</style:style>
+<style:style style:name="resurrection" style:display-name="resurrection" style:family="text">
+<style:text-properties fo:font-weight="bold" fo:font-variant="small-caps"/>
+</style:style>
+<style:style style:name="turn-to" style:display-name="turn-to" style:family="text"/>
+<style:style style:name="revealed" style:display-name="revealed" style:family="text">
+<style:text-properties fo:font-style="italic" fo:color="#a0522d"/>
+</style:style>
+<style:style style:name="Internet_20_link" style:display-name="Internet link" style:family="text"/>
+<style:style style:name="table" style:display-name="table" style:family="table">
+<style:table-properties style:width="16cm" table:align="center"/>
+</style:style>
+<style:style style:name="cell" style:display-name="cell" style:family="table-cell">
+<style:table-cell-properties fo:padding="0.1cm" fo:border="0.5pt solid #000000"/>
+</style:style>
+<style:style style:name="header-cell" style:display-name="header-cell" style:family="table-cell">
+<style:table-cell-properties fo:padding="0.1cm" fo:border="0.5pt solid #000000" fo:background-color="#d3d3d3"/>
+</style:style>
+</office:styles>
+</office:document-styles>
+`
+
+const ODT_HEADING =	// style, level, bookmark, title
+`<text:h text:style-name="%s" text:outline-level="%d"><text:bookmark text:name="%s"/>%s</text:h>
+`
+
+const ODT_LINK =	// bookmark, content
+`<text:a xlink:type="simple" xlink:href="#%s" text:style-name="Internet_20_link">%s</text:a>`
+
+const ODT_SPAN =	// style, content
+`<text:span text:style-name="%s">%s</text:span>`
+
+const ODT_IMAGE =	// name, width, height, path
+`<text:p text:style-name="image"><draw:frame draw:name="%s" text:anchor-type="as-char" svg:width="%.2fcm" svg:height="%.2fcm"><draw:image xlink:href="%s" xlink:type="simple" xlink:show="embed" xlink:actuate="onLoad"/></draw:frame></text:p>
+`
+
+// An odtCell is a table cell: its content is already escaped.
+type odtCell struct {
+	content string
+	header bool
+}
+
+type odtWriter struct {
+	out strings.Builder
+	para string
+	pictures map[string]string
+	order []string
+	tables int
+	book *Book
+}
+
+func writeODT(filename string, document Document) error {
+	w := odtWriter{pictures: make(map[string]string)}
+
+	w.out.WriteString(fmt.Sprintf(ODT_HEADING, "title", 1, "cover", "Fabled Lands"))
+	for _, s := range document.Rules {
+		w.section(s)
+	}
+	w.out.WriteString(fmt.Sprintf(ODT_HEADING, "section-title", 1, "map-world", "World Map"))
+	w.image(WORLDMAP_NAME)
+
+	for i := range document.Books {
+		w.book = &document.Books[i]
+		w.out.WriteString(fmt.Sprintf(ODT_HEADING, "title", 1, fmt.Sprintf("book-%d", w.book.Number), escapeXML(w.book.Title)))
+		w.out.WriteString(fmt.Sprintf(ODT_HEADING, "section-title", 2, "map-" + linkify(w.book.Region), escapeXML(w.book.Region)))
+		w.image(w.book.Map)
+		for _, s := range w.book.Sections {
+			w.section(s)
+		}
+	}
+	w.book = nil
+	w.appendices(document)
+
+	// Assemble the archive
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	archive := zip.NewWriter(file)
+
+	// The mimetype must come first, and must not be compressed
+	mimetype, err := archive.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	mimetype.Write([]byte(ODT_MIMETYPE))
+
+	var entries string
+	for _, path := range w.order {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		entry, err := archive.Create(w.pictures[path])
+		if err != nil {
+			return err
+		}
+		entry.Write(data)
+		entries += fmt.Sprintf(ODT_MANIFEST_ENTRY, w.pictures[path], contentType(path))
+	}
+
+	files := []struct{ name, content string }{
+		{"content.xml", fmt.Sprintf(ODT_CONTENT, w.out.String())},
+		{"styles.xml", ODT_STYLES},
+		{"META-INF/manifest.xml", fmt.Sprintf(ODT_MANIFEST, entries)},
+	}
+	for _, f := range files {
+		entry, err := archive.Create(f.name)
+		if err != nil {
+			return err
+		}
+		entry.Write([]byte(f.content))
+	}
+	return archive.Close()
+}
+
+func (w *odtWriter) section(s Section) {
+	name := escapeXML(s.Name)
+	name += sectionBoxes(s.ID, s.Boxes)
+	w.out.WriteString(fmt.Sprintf(ODT_HEADING, "section-title", 2, escapeXML(s.ID), name))
+	if s.Profession != "" && w.book != nil {
+		for _, p := range w.book.Professions {
+			if p.Name == s.Profession {
+				w.profession(p)
+			}
+		}
+	}
+	w.nodes(s.Content)
+	w.flush()
+}
+
+// inline adds content to the pending paragraph.
+func (w *odtWriter) inline(s string) {
+	w.para += s
+}
+
+// flush closes the pending paragraph, if there is one.
+func (w *odtWriter) flush() {
+	if p := strings.Join(strings.Fields(w.para), " "); p != "" {
+		w.out.WriteString("<text:p text:style-name=\"Standard\">" + p + "</text:p>\n")
+	}
+	w.para = ""
+}
+
+func (w *odtWriter) paragraph(style, content string) {
+	w.flush()
+	w.out.WriteString("<text:p text:style-name=\"" + style + "\">" + content + "</text:p>\n")
+}
+
+// image adds a picture to the archive and shows it at the width of the page.
+func (w *odtWriter) image(path string) {
+	w.flush()
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Println("Could not embed image:", err)
+		return
+	}
+	defer file.Close()
+	name, ok := w.pictures[path]
+	if !ok {
+		name = fmt.Sprintf("%s/%d%s", ODT_PICTURES, len(w.order) + 1, strings.ToLower(filepath.Ext(path)))
+		w.pictures[path] = name
+		w.order = append(w.order, path)
+	}
+	height := ODT_IMAGE_WIDTH * 3 / 4
+	if config, _, err := image.DecodeConfig(file); err == nil && config.Width > 0 {
+		height = ODT_IMAGE_WIDTH * float64(config.Height) / float64(config.Width)
+	}
+	w.out.WriteString(fmt.Sprintf(ODT_IMAGE, escapeXML(filepath.Base(name)), ODT_IMAGE_WIDTH, height, name))
+}
+
+// table lays the rows out in a table. A row with a single cell spans the whole table.
+func (w *odtWriter) table(style string, rows [][]odtCell) {
+	w.flush()
+	var columns int
+	for _, r := range rows {
+		columns = max(columns, len(r))
+	}
+	if columns == 0 {
+		return
+	}
+	w.tables++
+	w.out.WriteString(fmt.Sprintf("<table:table table:name=\"Table%d\" table:style-name=\"table\">\n", w.tables))
+	w.out.WriteString(fmt.Sprintf("<table:table-column table:number-columns-repeated=\"%d\"/>\n", columns))
+	for _, r := range rows {
+		w.out.WriteString("<table:table-row>")
+		for i, c := range r {
+			cellStyle, paragraphStyle := "cell", style
+			if c.header {
+				cellStyle, paragraphStyle = "header-cell", "table-header"
+			}
+			span := ""
+			if i == len(r) - 1 && len(r) < columns {
+				span = fmt.Sprintf(" table:number-columns-spanned=\"%d\"", columns - len(r) + 1)
+			}
+			w.out.WriteString(fmt.Sprintf("<table:table-cell table:style-name=\"%s\" office:value-type=\"string\"%s><text:p text:style-name=\"%s\">%s</text:p></table:table-cell>", cellStyle, span, paragraphStyle, c.content))
+			if span != "" {
+				w.out.WriteString(strings.Repeat("<table:covered-table-cell/>", columns - len(r)))
+			}
+		}
+		w.out.WriteString("</table:table-row>\n")
+	}
+	w.out.WriteString("</table:table>\n")
+}
+
+func (w *odtWriter) nodes(nodes []Node) {
+	for _, n := range nodes {
+		w.node(n)
+	}
+}
+
+func (w *odtWriter) node(n Node) {
+	if !visible(n) {
+		return
+	}
+	// Paragraphs start with their label, the other blocks are preceded by it
+	label := blockLabel(n)
+	if label != "" && n.Type != NODE_PARAGRAPH {
+		w.inline(odtStyle(CLASS_REVEALED, label) + " ")
+	}
+	switch n.Type {
+		case NODE_PARAGRAPH:
+			w.flush()
+			if label != "" {
+				w.inline(odtStyle(CLASS_REVEALED, label) + " ")
+			}
+			w.nodes(n.Children)
+			w.flush()
+
+		case NODE_MARKET:
+			rows := [][]odtCell{{{"Item", true}, {"Buy Price", true}, {"Sell Price", true}}}
+			for _, c := range n.Children {
+				switch {
+					case !visible(c):
+					case c.Type == NODE_HEADER:
+						rows = append(rows, []odtCell{{escapeXML(c.Text), true}})
+					case c.Price != nil:
+						buy, sell := c.Price.Buy, c.Price.Sell
+						if buy == "" {
+							buy = "-"
+						}
+						if sell == "" {
+							sell = "-"
+						}
+						rows = append(rows, []odtCell{{w.inlineNode(c), false}, {escapeXML(buy), false}, {escapeXML(sell), false}})
+					default:
+						rows = append(rows, []odtCell{{w.inlineNode(c), false}})
+				}
+			}
+			w.table("shop-item", rows)
+
+		case NODE_CHOICES, NODE_OUTCOMES:
+			var rows [][]odtCell
+			for _, c := range n.Children {
+				switch {
+					case !visible(c):
+					case isRow(c):
+						row := []odtCell{{escapeXML(rowLabel(c)), true}, {w.inlineNodes(c.Children), false}}
+						if c.Target != nil {
+							turnTo := fmt.Sprintf(ODT_SPAN, "turn-to", escapeXML(fmt.Sprintf(TXT_TURNTO, targetLabel(c))))
+							row = append(row, odtCell{fmt.Sprintf(ODT_LINK, escapeXML(c.Target.ID), turnTo), false})
+						}
+						rows = append(rows, row)
+					default:
+						if text := w.inlineNode(c); strings.TrimSpace(text) != "" {
+							rows = append(rows, []odtCell{{text, false}})
+						}
+				}
+			}
+			if len(rows) > 0 {
+				w.table("table-cell", rows)
+			}
+
+		case NODE_FIGHT:
+			f := n.Fight
+			w.table("fight", [][]odtCell{
+				{{escapeXML(f.Name), true}},
+				{{fmt.Sprintf("Combat: %d", f.Combat), false}, {fmt.Sprintf("Defence: %d", f.Defence), false}, {fmt.Sprintf("Stamina: %d", f.Stamina), false}},
+			})
+			if *combat && fightHasOdds(bookProfessions(w.book), *f) {
+				w.paragraph("Standard", escapeXML(fightOddsText(bookProfessions(w.book), *f)))
+			}
+
+		case NODE_IMAGE:
+			if w.book != nil {
+				w.image(filepath.Join(w.book.Dir, n.File))
+			}
+
+		case NODE_CACHE:
+			if n.Tag == "moneycache" {
+				w.paragraph("Standard", "Please write the amount in your sheet instead.")
+			} else {
+				w.paragraph("subtitle", escapeXML(n.Text))
+				w.table("table-cell", [][]odtCell{{{"<text:line-break/><text:line-break/><text:line-break/><text:line-break/>", false}}})
+			}
+
+		case NODE_HEADER:
+			w.paragraph("subtitle", escapeXML(n.Text))
+
+		case NODE_NOTE:
+			// Only shown with -reveal, in a paragraph of its own
+			w.flush()
+			w.inline(w.inlineNode(n))
+			w.flush()
+
+		case NODE_CONDITION, NODE_ELEMENT:
+			if hasBlocks(n.Children) {
+				if n.Type == NODE_CONDITION {
+					w.inline(condition(n.Attributes, plainText(n.Children), odtStyle))
+				}
+				w.nodes(n.Children)
+			} else {
+				w.inline(w.inlineNode(n))
+			}
+
+		default:
+			w.inline(w.inlineNode(n))
+	}
+}
+
+func (w *odtWriter) inlineNodes(nodes []Node) (out string) {
+	for _, n := range nodes {
+		out += w.inlineNode(n)
+	}
+	return strings.TrimSpace(out)
+}
+
+func (w *odtWriter) inlineNode(n Node) (out string) {
+	if !visible(n) {
+		return
+	}
+	if label := inlineLabel(n); label != "" {
+		// Whatever the node turns out to be, the label goes in front of it
+		defer func() {
+			out = odtStyle(CLASS_REVEALED, label) + " " + out
+		}()
+	}
+	if n.Type == NODE_TEXT {
+		return escapeXML(n.Text)
+	}
+	if isRow(n) {
+		// A branch option that is not inside a table
+		var turnTo string
+		if n.Target != nil {
+			turnTo = fmt.Sprintf(ODT_LINK, escapeXML(n.Target.ID), fmt.Sprintf(ODT_SPAN, "turn-to", escapeXML(fmt.Sprintf(TXT_TURNTO, targetLabel(n)))))
+		}
+		return " " + joinWords(escapeXML(rowLabel(n)), w.inlineNodes(n.Children), turnTo) + " "
+	}
+	if s, ok := wording(n, bookProfessions(w.book), odtStyle); ok {
+		out = s
+	} else {
+		switch {
+			case n.Type == NODE_FIGHT:
+				out = fmt.Sprintf(ODT_SPAN, "item", escapeXML(n.Fight.Name)) + fmt.Sprintf(" (Combat %d, Defence %d, Stamina %d)", n.Fight.Combat, n.Fight.Defence, n.Fight.Stamina)
+			case n.Type == NODE_GROUP:
+				for _, c := range n.Children {
+					if c.Tag == "text" {
+						out += w.inlineNodes(c.Children)
+					}
+				}
+			case n.Type == NODE_CONDITION:
+				out = joinWords(condition(n.Attributes, plainText(n.Children), odtStyle), w.inlineNodes(n.Children))
+			default:
+				for _, c := range n.Children {
+					out += w.inlineNode(c)
+				}
+		}
+	}
+	if n.Target != nil {
+		out = fmt.Sprintf(ODT_LINK, escapeXML(n.Target.ID), out)
+	}
+	return
+}
+
+func odtStyle(class, text string) string {
+	if class == CLASS_PLAIN {
+		return escapeXML(text)
+	}
+	return fmt.Sprintf(ODT_SPAN, class, escapeXML(text))
+}
+
+func (w *odtWriter) profession(p Profession) {
+	w.paragraph("subtitle", escapeXML(p.Name))
+	var abilities, values []odtCell
+	for i, a := range ABILITIES {
+		abilities = append(abilities, odtCell{a, true})
+		if i < len(p.Abilities) {
+			values = append(values, odtCell{escapeXML(p.Abilities[i]), false})
+		}
+	}
+	rows := [][]odtCell{
+		abilities,
+		values,
+		{{"Stamina", true}, {"Rank", true}, {"Gold", true}},
+		{{escapeXML(p.Stamina), false}, {escapeXML(p.Rank), false}, {escapeXML(p.Gold), false}},
+		{{"Starting equipment", true}},
+	}
+	for _, e := range p.Equipment {
+		rows = append(rows, []odtCell{{escapeXML(capitalize(e.Type)), true}, {fmt.Sprintf(ODT_SPAN, "item", escapeXML(equipmentName(e))), false}})
+	}
+	w.table("table-cell", rows)
+}
+
+// appendices adds the Adventure Sheet, the Ship's Manifest, the Codewords
+// and the pre-generated characters at the end of the book.
+func (w *odtWriter) appendices(document Document) {
+	w.sheet("sheet", "Adventure Sheet", hero)
+
+	w.out.WriteString(fmt.Sprintf(ODT_HEADING, "section-title", 1, "manifest", "Ship's Manifest"))
+	var rows [][]odtCell
+	var header []odtCell
+	for _, c := range MANIFEST_COLUMNS {
+		header = append(header, odtCell{escapeXML(c), true})
+	}
+	rows = append(rows, header)
+	for i := 0; i < MANIFEST_ROWS; i++ {
+		var row []odtCell
+		for _, v := range manifestRow(i) {
+			row = append(row, odtCell{escapeXML(v), false})
+		}
+		rows = append(rows, row)
+	}
+	w.table("table-cell", rows)
+
+	for _, n := range codewordBooks() {
+		w.out.WriteString(fmt.Sprintf(ODT_HEADING, "section-title", 1, fmt.Sprintf("cd%d", n), "Codewords"))
+		w.paragraph("subtitle", escapeXML(bookTitle(n)))
+		for _, word := range readCodewords(n) {
+			w.paragraph("codeword", codewordBox(word) + " " + escapeXML(word))
+		}
+	}
+
+	if adventurers := pregeneratedAdventurers(document); len(adventurers) > 0 {
+		w.out.WriteString(fmt.Sprintf(ODT_HEADING, "title", 1, "pregenerated", PREGENERATED_TITLE))
+		for i := range adventurers {
+			w.sheet(adventurerID(adventurers[i]), escapeXML(adventurerTitle(adventurers[i])), &adventurers[i])
+		}
+	}
+
+	for _, r := range reports(document) {
+		w.report(r)
+	}
+}
+
+func (w *odtWriter) report(r Report) {
+	w.out.WriteString(fmt.Sprintf(ODT_HEADING, "title", 1, r.ID, escapeXML(r.Title)))
+	if r.Intro != "" {
+		w.paragraph("Standard", escapeXML(r.Intro))
+	}
+	for _, t := range r.Tables {
+		if t.Title != "" {
+			w.paragraph("subtitle", escapeXML(t.Title))
+		}
+		var rows [][]odtCell
+		if len(t.Header) > 0 {
+			var header []odtCell
+			for _, h := range t.Header {
+				header = append(header, odtCell{escapeXML(h), true})
+			}
+			rows = append(rows, header)
+		}
+		for _, row := range t.Rows {
+			var cells []odtCell
+			for _, c := range row {
+				var texts []string
+				for _, p := range c.parts() {
+					text := escapeXML(p.Text)
+					if p.Target != nil {
+						text = fmt.Sprintf(ODT_LINK, escapeXML(p.Target.ID), text)
+					}
+					texts = append(texts, text)
+				}
+				cells = append(cells, odtCell{strings.Join(texts, ", "), false})
+			}
+			rows = append(rows, cells)
+		}
+		w.table("table-cell", rows)
+	}
+}
+
+// sheet adds an Adventure Sheet, filled in with an adventurer if there is one.
+func (w *odtWriter) sheet(id, title string, a *Adventurer) {
+	w.out.WriteString(fmt.Sprintf(ODT_HEADING, "section-title", 1, id, title))
+	var rows [][]odtCell
+	for _, f := range SHEET_FIELDS {
+		value := escapeXML(sheetValue(a, f))
+		if t, text := sheetLink(a, f); t != nil {
+			value = fmt.Sprintf(ODT_LINK, escapeXML(t.ID), escapeXML(text))
+		}
+		rows = append(rows, []odtCell{{escapeXML(f), true}, {value, false}})
+	}
+	for i := 1; i <= SHEET_POSSESSIONS; i++ {
+		rows = append(rows, []odtCell{{fmt.Sprintf("Possession %d", i), true}, {escapeXML(possessionValue(a, i-1)), false}})
+	}
+	rows = append(rows, []odtCell{{SHEET_BLESSINGS, true}, {escapeXML(sheetValue(a, SHEET_BLESSINGS)) + "<text:line-break/><text:line-break/>", false}})
+	w.table("table-cell", rows)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+)
+
+// --- PARALLEL PARSING ---
+// The files of a book are parsed at the same time by a pool of workers, as many as -jobs,
+// and handed over in the order of betterSort as soon as they are ready. parse() keeps what
+// it finds in a file in a parsed value instead of globals, so that files do not get in each other's way.
+// Books are still converted one after another, since replace() reads the book from 'book' and 'dir'.
+
+// Files parsed ahead of the one being handed over, for each worker
+const PARSE_AHEAD = 2
+
+// Everything parse() gets from a file
+type parsed struct {
+	filename string
+	output *strings.Builder	// HTML of the sections of the file
+	sections []Section
+	section string	// Section being parsed, where unknown tags are found
+	unknown []tagLocation
+}
+
+// parseFiles parses files with a pool of workers, and hands the results over in the order of the files.
+// Only a few files are parsed ahead, so that the HTML of a whole book is never held in memory.
+// The model of the sections is still kept, for the reports and the other formats.
+func parseFiles(filenames []string, each func(p parsed, err error)) {
+	type result struct {
+		p parsed
+		err error
+	}
+	workers := max(*jobs, 1)
+	scope := cacheScope()
+	done := make([]chan result, len(filenames))
+	for i := range done {
+		done[i] = make(chan result, 1)
+	}
+	queue := make(chan int)
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range queue {
+				p, err := parseCached(filenames[i], scope)
+				done[i] <- result{p, err}
+			}
+		}()
+	}
+	ahead := make(chan bool, workers * PARSE_AHEAD)
+	go func() {
+		for i := range filenames {
+			ahead <- true
+			queue <- i
+		}
+		close(queue)
+	}()
+	for i := range filenames {
+		r := <-done[i]
+		each(r.p, r.err)
+		<-ahead
+	}
+}
+
+// collect takes in the results of parse(), in the order of the output:
+// its errors are recorded, and its unknown tags counted.
+func collect(p parsed, err error) parsed {
+	p = skipBroken(p, err)
+	countUnknownTags(p)
+	return p
+}
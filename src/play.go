@@ -0,0 +1,571 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// --- PLAY ---
+// The 'play' command runs the books in a terminal, from the book model.
+// It shows a section, numbers its choices, rolls the dice for the checks,
+// and keeps the Adventure Sheet up to date with what the section gives and takes.
+// Anything it cannot apply by itself (fights, conditions, diseases...) is left to the player,
+// who can still read it in the text of the section.
+
+const DEFAULT_SAVE = "jafl-save.json"
+
+const PLAY_HELP =
+`Commands:
+  <number>       follow a choice
+  roll           roll the dice for the rolls and checks of the section
+  take <number>  take an item found in the section
+  buy <number>   buy an item of the market
+  sell <number>  sell an item to the market
+  drop <name>    drop a possession
+  sheet          show the Adventure Sheet
+  look           show the section again
+  save [file]    save the game
+  load [file]    load a saved game
+  help           show this help
+  quit           leave the game (it is not saved)
+`
+
+type game struct {
+	hero Adventurer
+	books map[int]*Book
+	sections map[string]*Section
+	saveFile string
+	in *bufio.Scanner
+
+	// What the current section offers
+	choices []Node
+	rolls []roll
+	items []Node
+	offers []Node
+}
+
+// A roll remembers where it is in the section, to tell which choice it leads to.
+type roll struct {
+	node Node
+	choice int
+}
+
+func play(args []string) {
+	flags := flag.NewFlagSet(COMMAND_PLAY, flag.ExitOnError)
+	start := flags.Int("b", 1, "Number of the book to start in")
+	profession := flags.String("profession", "", "Starting profession (asked if not given)")
+	loadFile := flags.String("load", "", "Saved game to continue")
+	saveFile := flags.String("save", DEFAULT_SAVE, "File the game is saved to")
+	flags.IntVar(width, "width", DEFAULT_WIDTH, "Line width of the text")
+	flags.Parse(args)
+
+	root = flags.Arg(0)
+	if root == "" {
+		root = DEFAULT_DIR
+	}
+
+	g := game{books: make(map[int]*Book), sections: make(map[string]*Section), saveFile: *saveFile}
+	g.in = bufio.NewScanner(os.Stdin)
+
+	// Load all the books quietly
+	fmt.Println("Loading the books...")
+	progress = io.Discard
+	for i, d := range listBooks() {
+		bookModel := loadBook(i+1, stripExt(d), io.Discard)
+		g.books[bookModel.Number] = &bookModel
+		for j := range bookModel.Sections {
+			g.sections[bookModel.Sections[j].ID] = &bookModel.Sections[j]
+		}
+	}
+	progress = os.Stdout
+
+	if *loadFile != "" {
+		hero, err := loadAdventurer(*loadFile)
+		check(err)
+		g.hero = hero
+	} else {
+		bk, ok := g.books[*start]
+		if !ok {
+			check(fmt.Errorf("Book %d not found", *start))
+		}
+		p, ok := g.chooseProfession(bk, *profession)
+		if !ok {
+			return
+		}
+		g.hero = newAdventurer(p)
+		g.hero.Section = startingSection(bk, p.Name)
+	}
+
+	g.enter(g.hero.Section, true)
+	for {
+		fmt.Print("> ")
+		if !g.in.Scan() {
+			fmt.Println()
+			return
+		}
+		if !g.command(strings.Fields(g.in.Text())) {
+			return
+		}
+	}
+}
+
+func (g *game) chooseProfession(bk *Book, name string) (p Profession, ok bool) {
+	for _, p := range bk.Professions {
+		if strings.EqualFold(p.Name, name) {
+			return p, true
+		}
+	}
+	if name != "" {
+		fmt.Printf("Found no profession called %s.\n", name)
+	}
+	fmt.Println("Choose a profession:")
+	for i, p := range bk.Professions {
+		fmt.Printf("%d) %s, %s\n", i+1, p.PersonName, p.Name)
+	}
+	for {
+		fmt.Print("> ")
+		if !g.in.Scan() {
+			return
+		}
+		if i, err := strconv.Atoi(strings.TrimSpace(g.in.Text())); err == nil && i >= 1 && i <= len(bk.Professions) {
+			return bk.Professions[i-1], true
+		}
+		fmt.Println("Type the number of a profession.")
+	}
+}
+
+// startingSection is the section of a profession, or the first section of the book.
+func startingSection(bk *Book, profession string) string {
+	for _, s := range bk.Sections {
+		if s.Profession == profession {
+			return s.ID
+		}
+	}
+	if len(bk.Sections) > 0 {
+		return bk.Sections[0].ID
+	}
+	return ""
+}
+
+// command runs a line typed by the player, and tells whether the game goes on.
+func (g *game) command(words []string) bool {
+	if len(words) == 0 {
+		return true
+	}
+	arg := strings.Join(words[1:], " ")
+	if n, err := strconv.Atoi(words[0]); err == nil {
+		if n < 1 || n > len(g.choices) {
+			fmt.Println("There is no such choice.")
+		} else {
+			g.enter(g.choices[n-1].Target.ID, false)
+		}
+		return true
+	}
+	switch strings.ToLower(words[0]) {
+		case "r", "roll":
+			g.roll()
+		case "take":
+			g.take(arg)
+		case "buy":
+			g.trade(arg, true)
+		case "sell":
+			g.trade(arg, false)
+		case "drop":
+			if i := g.hero.possession(arg); i < 0 {
+				fmt.Println("You do not have", arg)
+			} else {
+				fmt.Println("You drop", equipmentName(g.hero.Possessions[i]))
+				g.hero.Possessions = append(g.hero.Possessions[:i], g.hero.Possessions[i+1:]...)
+			}
+		case "s", "sheet":
+			fmt.Print(asciiTable(g.hero.sheetRows(), *width))
+		case "l", "look":
+			g.show(g.sections[g.hero.Section])
+		case "save":
+			if arg != "" {
+				g.saveFile = arg
+			}
+			if err := writeJSON(g.saveFile, g.hero); err != nil {
+				fmt.Println("Could not save the game:", err)
+			} else {
+				fmt.Println("Game saved in", g.saveFile)
+			}
+		case "load":
+			if arg != "" {
+				g.saveFile = arg
+			}
+			hero, err := loadAdventurer(g.saveFile)
+			if err != nil {
+				fmt.Println("Could not load the game:", err)
+			} else {
+				g.hero = hero
+				g.enter(g.hero.Section, true)
+			}
+		case "h", "help", "?":
+			fmt.Print(PLAY_HELP)
+		case "q", "quit", "exit":
+			return false
+		default:
+			fmt.Println("Unknown command. Type 'help' for the list of commands.")
+	}
+	return true
+}
+
+// enter moves the adventurer to a section and applies its effects, unless the game was just loaded.
+func (g *game) enter(id string, loaded bool) {
+	s, ok := g.sections[id]
+	if !ok {
+		fmt.Printf("Section %s is not in the books that were found. Choose another way, or load a game.\n", id)
+		return
+	}
+	g.hero.Section = id
+	g.choices, g.rolls, g.items, g.offers = nil, nil, nil, nil
+	g.collect(s.Content, false)
+	g.show(s)
+	if !loaded {
+		g.effects(s.Content)
+	}
+}
+
+// collect finds what the player can do in a section.
+// Items inside a market or a choice are not lying around for the taking.
+func (g *game) collect(nodes []Node, inside bool) {
+	for _, n := range nodes {
+		if !visible(n) {
+			continue
+		}
+		switch n.Type {
+			case NODE_ROLL, NODE_CHECK:
+				g.rolls = append(g.rolls, roll{node: n, choice: len(g.choices)})
+			case NODE_ITEM:
+				switch {
+					case n.Price != nil || n.Tag == "buy" || n.Tag == "sell":
+						g.offers = append(g.offers, n)
+					case !inside && n.Tag != "gain" && n.Tag != "lose" && n.Tag != "trade":
+						g.items = append(g.items, n)
+				}
+		}
+		if n.Target != nil && n.Type != NODE_GROUP && !g.chosen(n) {
+			g.choices = append(g.choices, n)
+		}
+		g.collect(n.Children, inside || n.Type == NODE_MARKET || n.Type == NODE_CHOICE || n.Type == NODE_OUTCOME)
+	}
+}
+
+// chosen tells whether a choice is already listed, since sections often repeat them.
+func (g *game) chosen(n Node) bool {
+	for _, c := range g.choices {
+		if c.Target.ID == n.Target.ID && choiceLabel(c) == choiceLabel(n) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *game) show(s *Section) {
+	w := textWriter{width: *width, book: g.books[s.Book]}
+	name := s.Name
+	if ticks := g.hero.Ticks[s.ID]; s.Boxes > 0 {
+		name += strings.Repeat(" " + TICKED, ticks) + strings.Repeat(" " + TICKBOX, max(s.Boxes - ticks, 0))
+	}
+	fmt.Print("\n" + bookTitle(s.Book) + "\n")
+	w.heading(name)
+	if s.Profession != "" && w.book != nil {
+		for _, p := range w.book.Professions {
+			if p.Name == s.Profession {
+				w.profession(p)
+			}
+		}
+	}
+	w.nodes(s.Content)
+	w.flush()
+	fmt.Print(w.out.String())
+
+	for i, n := range g.items {
+		fmt.Printf("Take %d: %s\n", i+1, w.inlineNode(n))
+	}
+	for i, n := range g.offers {
+		fmt.Printf("Trade %d: %s%s\n", i+1, w.inlineNode(n), priceLabel(n))
+	}
+	if len(g.rolls) > 0 {
+		fmt.Println("Type 'roll' to roll the dice.")
+	}
+	for i, c := range g.choices {
+		fmt.Printf("%d) %s\n", i+1, joinWords(choiceLabel(c), fmt.Sprintf(TXT_TURNTO, targetLabel(c))))
+	}
+	fmt.Println()
+}
+
+func choiceLabel(n Node) string {
+	return joinWords(rowLabel(n), plainText(n.Children))
+}
+
+// effects applies the ticks, gains and losses of a section, leaving out those that depend on a choice.
+func (g *game) effects(nodes []Node) {
+	for _, n := range nodes {
+		if !visible(n) {
+			continue
+		}
+		switch n.Type {
+			case NODE_TICK:
+				if n.Codeword != "" {
+					if !g.hero.HasCodeword(n.Codeword) {
+						g.hero.Codewords = append(g.hero.Codewords, n.Codeword)
+					}
+					fmt.Println("You now have the codeword", n.Codeword)
+				} else if s := g.sections[g.hero.Section]; s != nil {
+					g.hero.Ticks[s.ID]++
+					fmt.Println("The box of this section is ticked.")
+				}
+			case NODE_ITEM:
+				switch n.Tag {
+					case "gain":
+						g.change(n, 1)
+					case "lose":
+						g.change(n, -1)
+				}
+			case NODE_CHOICES, NODE_OUTCOMES, NODE_CONDITION, NODE_MARKET:
+				continue
+		}
+		g.effects(n.Children)
+	}
+}
+
+// change applies a 'gain' or a 'lose' tag to the adventurer.
+func (g *game) change(n Node, sign int) {
+	a := n.Attributes
+	amount := func(k string) (int, bool) {
+		v, err := strconv.Atoi(a[k])
+		return sign * v, err == nil
+	}
+	switch {
+		case a["shards"] != "":
+			if v, ok := amount("shards"); ok {
+				g.hero.Shards = max(g.hero.Shards + v, 0)
+				fmt.Printf("Money: %+d Shards (now %d)\n", v, g.hero.Shards)
+				return
+			}
+		case a["stamina"] != "":
+			if v, ok := amount("stamina"); ok {
+				g.hero.Stamina = min(g.hero.Stamina + v, g.hero.MaxStamina)
+				fmt.Printf("Stamina: %+d (now %d)\n", v, g.hero.Stamina)
+				if g.hero.Stamina <= 0 {
+					fmt.Println("You are dead, unless you have a resurrection arrangement.")
+				}
+				return
+			}
+		case a["rank"] != "":
+			if v, ok := amount("rank"); ok {
+				g.hero.Rank += v
+				fmt.Printf("Rank: %+d (now %d)\n", v, g.hero.Rank)
+				return
+			}
+		case a["ability"] != "" && abilityName(a["ability"]) != "":
+			if v, ok := amount("amount"); ok {
+				ab := abilityName(a["ability"])
+				g.hero.Abilities[ab] += v
+				fmt.Printf("%s: %+d (now %d)\n", ab, v, g.hero.Abilities[ab])
+				return
+			}
+		case a["title"] != "":
+			if sign > 0 {
+				g.hero.Titles = append(g.hero.Titles, a["title"])
+			} else if i := indexFold(g.hero.Titles, a["title"]); i >= 0 {
+				g.hero.Titles = append(g.hero.Titles[:i], g.hero.Titles[i+1:]...)
+			}
+			fmt.Println("Title:", a["title"])
+			return
+		case a["codeword"] != "":
+			if sign > 0 && !g.hero.HasCodeword(a["codeword"]) {
+				g.hero.Codewords = append(g.hero.Codewords, a["codeword"])
+			} else if i := indexFold(g.hero.Codewords, a["codeword"]); sign < 0 && i >= 0 {
+				g.hero.Codewords = append(g.hero.Codewords[:i], g.hero.Codewords[i+1:]...)
+			}
+			fmt.Println("Codeword:", a["codeword"])
+			return
+		case n.Item != nil:
+			if sign > 0 {
+				g.give(*n.Item)
+			} else if i := g.hero.possession(n.Item.Name); i >= 0 {
+				g.hero.Possessions = append(g.hero.Possessions[:i], g.hero.Possessions[i+1:]...)
+				fmt.Println("You lose", n.Item.Name)
+			} else {
+				fmt.Println("You do not have", n.Item.Name)
+			}
+			return
+	}
+	fmt.Println("Update your Adventure Sheet yourself:", n.Tag, itemName(element{Name: n.Tag, Attributes: a}))
+}
+
+func (g *game) give(item Item) bool {
+	if len(g.hero.Possessions) >= SHEET_POSSESSIONS {
+		fmt.Printf("You cannot carry more than %d possessions. Drop something first.\n", SHEET_POSSESSIONS)
+		return false
+	}
+	g.hero.Possessions = append(g.hero.Possessions, item)
+	fmt.Println("You now have", equipmentName(item))
+	return true
+}
+
+func (g *game) take(arg string) {
+	i, err := strconv.Atoi(arg)
+	if err != nil || i < 1 || i > len(g.items) {
+		fmt.Println("There is no such item here.")
+		return
+	}
+	g.give(*g.items[i-1].Item)
+}
+
+func (g *game) trade(arg string, buying bool) {
+	i, err := strconv.Atoi(arg)
+	if err != nil || i < 1 || i > len(g.offers) {
+		fmt.Println("There is no such item to trade.")
+		return
+	}
+	n := g.offers[i-1]
+	buy, sell := offerPrices(n)
+	if buying {
+		price, err := strconv.Atoi(buy)
+		switch {
+			case err != nil:
+				fmt.Println("That cannot be bought here.")
+			case price > g.hero.Shards:
+				fmt.Printf("You need %d Shards, and only have %d.\n", price, g.hero.Shards)
+			case g.give(*n.Item):
+				g.hero.Shards -= price
+				fmt.Printf("You pay %d Shards (%d left)\n", price, g.hero.Shards)
+		}
+	} else {
+		price, err := strconv.Atoi(sell)
+		j := g.hero.possession(n.Item.Name)
+		switch {
+			case err != nil:
+				fmt.Println("That cannot be sold here.")
+			case j < 0:
+				fmt.Println("You do not have", n.Item.Name)
+			default:
+				g.hero.Possessions = append(g.hero.Possessions[:j], g.hero.Possessions[j+1:]...)
+				g.hero.Shards += price
+				fmt.Printf("You sell %s for %d Shards (now %d)\n", n.Item.Name, price, g.hero.Shards)
+		}
+	}
+}
+
+// offerPrices reads the prices of a market row, or of a lone 'buy' or 'sell' tag.
+func offerPrices(n Node) (buy, sell string) {
+	if n.Price != nil {
+		return n.Price.Buy, n.Price.Sell
+	}
+	switch n.Tag {
+		case "buy":
+			buy = n.Attributes["shards"]
+		case "sell":
+			sell = n.Attributes["shards"]
+	}
+	return
+}
+
+func priceLabel(n Node) (out string) {
+	buy, sell := offerPrices(n)
+	if buy != "" {
+		out += ", buy for " + buy
+	}
+	if sell != "" {
+		out += ", sell for " + sell
+	}
+	return
+}
+
+// roll rolls the dice for every roll and check of the section, and points to the choice each one leads to.
+func (g *game) roll() {
+	if len(g.rolls) == 0 {
+		fmt.Println("There is nothing to roll for here.")
+		return
+	}
+	for _, r := range g.rolls {
+		dice := r.node.Check.Dice
+		if dice == 0 {
+			dice = 2
+		}
+		faces, total := rollDice(dice)
+		text := faces
+		var outcome func(Node) bool
+		switch {
+			case r.node.Type == NODE_CHECK:
+				score := g.hero.Ability(r.node.Check.Ability)
+				success := total + score > r.node.Check.Level
+				text += fmt.Sprintf(" + %s %d = %d against %d: %s", capitalize(strings.ToLower(r.node.Check.Ability)), score, total + score, r.node.Check.Level, result(success))
+				outcome = func(c Node) bool { return c.Tag == successTag(success) }
+			case r.node.Tag == "rankcheck":
+				success := total < g.hero.Rank
+				text += fmt.Sprintf(" against Rank %d: %s", g.hero.Rank, result(success))
+				outcome = func(c Node) bool { return c.Tag == successTag(success) }
+			default:
+				outcome = func(c Node) bool { return c.Type == NODE_OUTCOME && inRange(c.Range, total) }
+		}
+		for i := r.choice; i < len(g.choices); i++ {
+			if outcome(g.choices[i]) {
+				text += fmt.Sprintf(" → choice %d", i+1)
+				break
+			}
+		}
+		fmt.Println("You roll", text)
+	}
+}
+
+func rollDice(dice int) (faces string, total int) {
+	var parts []string
+	for i := 0; i < dice; i++ {
+		face := 1 + rand.IntN(6)
+		parts = append(parts, strconv.Itoa(face))
+		total += face
+	}
+	faces = strings.Join(parts, " + ")
+	if dice > 1 {
+		faces += " = " + strconv.Itoa(total)
+	}
+	return
+}
+
+func result(success bool) string {
+	if success {
+		return "success!"
+	}
+	return "failure"
+}
+
+func successTag(success bool) string {
+	if success {
+		return "success"
+	}
+	return "failure"
+}
+
+// inRange tells whether a roll is in a range like "4", "4-6" or "12+".
+func inRange(r string, total int) bool {
+	r = strings.TrimSpace(r)
+	switch {
+		case strings.HasSuffix(r, "+"):
+			return total >= atoi(strings.TrimSuffix(r, "+"))
+		case strings.Contains(r, "-"):
+			low, high, _ := strings.Cut(r, "-")
+			return total >= atoi(low) && total <= atoi(high)
+		default:
+			return total == atoi(r)
+	}
+}
+
+func indexFold(list []string, s string) int {
+	for i, v := range list {
+		if strings.EqualFold(v, s) {
+			return i
+		}
+	}
+	return -1
+}
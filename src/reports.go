@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// --- REPORTS ---
+// Reports are appendices computed from the book model, like the hardest checks of each book.
+// They are made of tables whose cells may point to a section, so that every format can lay them out.
+
+type Report struct {
+	ID string
+	Title string
+	Intro string
+	Tables []ReportTable
+}
+
+type ReportTable struct {
+	Title string
+	Header []string
+	Rows [][]ReportCell
+	Sortable bool	// The HTML table can be sorted by clicking its headers
+}
+
+type ReportCell struct {
+	Text string
+	Target *Target
+	List []ReportCell	// A cell made of several, like the sections where an enemy is met
+}
+
+// parts lists the cells that a cell is made of: itself, unless it holds a list.
+func (c ReportCell) parts() []ReportCell {
+	if len(c.List) > 0 {
+		return c.List
+	}
+	return []ReportCell{c}
+}
+
+// plain is the text of a cell, without its links.
+func (c ReportCell) plain() string {
+	var texts []string
+	for _, p := range c.parts() {
+		texts = append(texts, p.Text)
+	}
+	return strings.Join(texts, ", ")
+}
+
+// reports lists the reports asked for on the command line.
+func reports(document Document) (out []Report) {
+	if *odds {
+		out = append(out, hardestChecks(document))
+	}
+	if *bestiaryReport || *combat {
+		out = append(out, bestiary(document))
+	}
+	if *tradeReport {
+		out = append(out, trade(document))
+	}
+	if *itemsReport {
+		out = append(out, items(document))
+	}
+	if *templesReport {
+		out = append(out, templeDirectory(document))
+	}
+	return
+}
+
+// hasReports tells whether any report is asked for, without computing them.
+func hasReports() bool {
+	return *odds || *combat || *bestiaryReport || *tradeReport || *itemsReport || *templesReport
+}
+
+// sectionCell points to a section, by its name.
+func sectionCell(s Section) ReportCell {
+	return ReportCell{Text: s.Name, Target: &Target{Book: s.Book, Section: s.Name, ID: s.ID}}
+}
+
+// bookSectionCell points to a section, with the title of its book, for tables that cross books.
+func bookSectionCell(s Section) ReportCell {
+	c := sectionCell(s)
+	c.Text += " (" + bookTitle(s.Book) + ")"
+	return c
+}
+
+// --- HTML REPORTS ---
+
+const FMT_REPORT =	// id, title, intro, tables
+`
+<div class="page report" id="%s">
+	<h1>%s</h1>
+	%s
+%s
+</div>
+`
+const FMT_REPORT_TABLE =	// title, class, rows
+`	%s
+	<table class="%s">
+%s
+	</table>
+`
+const FMT_REPORT_TITLE =
+`<h2>%s</h2>`
+const FMT_REPORT_LINK =	// id, text
+`<a href="#%s">%s</a>`
+
+func htmlReports(document Document) (out string) {
+	sortable := false
+	for _, r := range reports(document) {
+		out += htmlReport(r)
+		for _, t := range r.Tables {
+			sortable = sortable || t.Sortable
+		}
+	}
+	if sortable {
+		out += REPORT_SORT_SCRIPT
+	}
+	return
+}
+
+func htmlReport(r Report) string {
+	var tables string
+	for _, t := range r.Tables {
+		var rows string
+		if len(t.Header) > 0 {
+			rows += "\t\t<tr>"
+			for _, h := range t.Header {
+				rows += "<th>" + html.EscapeString(h) + "</th>"
+			}
+			rows += "</tr>\n"
+		}
+		for _, row := range t.Rows {
+			rows += "\t\t<tr>"
+			for _, c := range row {
+				var texts []string
+				for _, p := range c.parts() {
+					text := html.EscapeString(p.Text)
+					if p.Target != nil {
+						text = fmt.Sprintf(FMT_REPORT_LINK, p.Target.ID, text)
+					}
+					texts = append(texts, text)
+				}
+				rows += "<td>" + strings.Join(texts, ", ") + "</td>"
+			}
+			rows += "</tr>\n"
+		}
+		var title string
+		if t.Title != "" {
+			title = fmt.Sprintf(FMT_REPORT_TITLE, html.EscapeString(t.Title))
+		}
+		class := "report"
+		if t.Sortable {
+			class += " sortable"
+		}
+		tables += fmt.Sprintf(FMT_REPORT_TABLE, title, class, strings.TrimSuffix(rows, "\n"))
+	}
+	var intro string
+	if r.Intro != "" {
+		intro = "<p>" + html.EscapeString(r.Intro) + "</p>"
+	}
+	return fmt.Sprintf(FMT_REPORT, r.ID, html.EscapeString(r.Title), intro, strings.TrimSuffix(tables, "\n"))
+}
+
+// Sorts a table by the column whose header is clicked, in numbers when it holds numbers
+const REPORT_SORT_SCRIPT =
+`
+<script>
+(function () {
+	function value(row, i) {
+		var text = row.children[i] ? row.children[i].textContent.trim() : "";
+		var number = parseFloat(text);
+		return isNaN(number) ? text.toLowerCase() : number;
+	}
+
+	document.querySelectorAll("table.sortable").forEach(function (table) {
+		var header = table.rows[0];
+		Array.prototype.forEach.call(header.children, function (th, i) {
+			th.style.cursor = "pointer";
+			th.addEventListener("click", function () {
+				var descending = th.dataset.sorted === "ascending";
+				var rows = Array.prototype.slice.call(table.rows, 1);
+				rows.sort(function (a, b) {
+					var x = value(a, i), y = value(b, i);
+					var order = typeof x === typeof y ? (x < y ? -1 : x > y ? 1 : 0) : (typeof x === "number" ? -1 : 1);
+					return descending ? -order : order;
+				});
+				Array.prototype.forEach.call(header.children, function (other) {
+					delete other.dataset.sorted;
+				});
+				th.dataset.sorted = descending ? "descending" : "ascending";
+				rows.forEach(function (row) {
+					row.parentNode.appendChild(row);
+				});
+			});
+		});
+	});
+})();
+</script>
+`
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// --- REPRODUCIBLE OUTPUT ---
+// With -check-reproducible, the books are converted twice, by two runs of the program with the same flags,
+// and the hashes of both outputs are compared. Any difference comes from the order of a map,
+// or from something else that changes between runs, and shows in the diffs between releases.
+
+// reproducible returns the exit code rather than exiting, so that the outputs of both runs are always removed.
+func reproducible() int {
+	executable, err := os.Executable()
+	check(err)
+	tmp, err := os.MkdirTemp("", "jafl-reproducible")
+	check(err)
+	defer os.RemoveAll(tmp)
+
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		// Without the cache, so that the second run converts the books again
+		if f.Name != "check-reproducible" && f.Name != "cache" {
+			args = append(args, "-" + f.Name + "=" + f.Value.String())
+		}
+	})
+
+	var hashes []string
+	for run := 1; run <= 2; run++ {
+		fmt.Printf("Converting, run %d... ", run)
+		out := filepath.Join(tmp, fmt.Sprintf("run%d%s", run, filepath.Ext(output)))
+		cmd := exec.Command(executable, append(args, root, out)...)
+		cmd.Stderr = os.Stderr
+		// Files skipped with -keep-going are skipped on both runs
+		if err := cmd.Run(); err != nil {
+			var exit *exec.ExitError
+			if !errors.As(err, &exit) || exit.ExitCode() != EXIT_PARSE_ERRORS {
+				fmt.Println(err)
+				return EXIT_FAILURE
+			}
+		}
+		// Gemtext is saved as a capsule directory, named after the output file
+		if *format == FORMAT_GEMTEXT {
+			out = stripExt(out)
+		}
+		hash, err := hashOutput(out)
+		if err != nil {
+			fmt.Println(err)
+			return EXIT_FAILURE
+		}
+		fmt.Println(hash)
+		hashes = append(hashes, hash)
+	}
+	if hashes[0] != hashes[1] {
+		fmt.Println("\nThe output is not reproducible: the two runs differ.")
+		return EXIT_NOT_REPRODUCIBLE
+	}
+	fmt.Println("\nThe output is reproducible.")
+	return EXIT_OK
+}
+
+// hashOutput is the SHA-256 of an output file, or of the names and contents of the files of an output directory.
+func hashOutput(path string) (string, error) {
+	hash := sha256.New()
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		io.WriteString(hash, filepath.ToSlash(rel) + "\x00")
+		_, err = io.Copy(hash, file)
+		return err
+	})
+	return hex.EncodeToString(hash.Sum(nil)), err
+}
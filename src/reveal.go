@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// --- REVEAL ---
+// With -reveal, the parts of the books that only the game engine sees are printed too,
+// for editors who proofread the books and game masters who run them at the table:
+// hidden tags, the 'desc' text of sections, and the 'adjust' and 'effect' modifiers put into words.
+// Each of them is marked with a label, like "[Hidden]", in a style of its own.
+
+const CLASS_REVEALED = "revealed"
+
+const FMT_REVEALED =	// revealLabel, content
+`<span class="revealed"><span class="reveal-label">%s</span> %s</span>`
+const FMT_REVEALED_BLOCK =	// revealLabel, content
+`<div class="revealed"><span class="reveal-label">%s</span>
+%s
+</div>`
+
+// Names of the modifiers, by tag
+var REVEAL_NOTES = map[string]string{
+	"desc": "Description",
+	"adjust": "Adjustment",
+	"effect": "Effect",
+}
+
+// Attributes that modifierWords tells on their own, or that tell nothing
+var MODIFIER_AMOUNTS = []string{"amount", "bonus", "value"}
+var MODIFIER_SKIPPED = []string{"ability", "hidden"}
+
+// revealLabel is the label of a part of a book that is only shown with -reveal, or nothing for the others.
+func revealLabel(n Node) string {
+	if !*reveal {
+		return ""
+	}
+	var labels []string
+	if n.Hidden {
+		labels = append(labels, "Hidden")
+	}
+	if n.Type == NODE_NOTE {
+		label := REVEAL_NOTES[n.Tag]
+		if words := modifierWords(n.Attributes); words != "" && n.Tag != "desc" {
+			label += ": " + words
+		}
+		labels = append(labels, label)
+	}
+	if len(labels) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(labels, ", ") + "]"
+}
+
+// blockLabel is the label that node() puts in front of a node, before laying out its blocks.
+func blockLabel(n Node) string {
+	if (!isInline(n) || hasBlocks(n.Children)) && n.Type != NODE_NOTE {
+		return revealLabel(n)
+	}
+	return ""
+}
+
+// inlineLabel is the label that inlineNode() puts in front of the nodes that blockLabel leaves out.
+func inlineLabel(n Node) string {
+	if blockLabel(n) == "" {
+		return revealLabel(n)
+	}
+	return ""
+}
+
+// modifierWords puts the attributes of a modifier into words, like "-1 to Combat, type: blessing".
+func modifierWords(attributes map[string]string) string {
+	var words []string
+	var amount string
+	for _, k := range MODIFIER_AMOUNTS {
+		if v := attributes[k]; v != "" && amount == "" {
+			amount = v
+		}
+	}
+	if amount != "" && !strings.HasPrefix(amount, "-") && !strings.HasPrefix(amount, "+") {
+		amount = "+" + amount
+	}
+	if ability := attributes["ability"]; ability != "" {
+		words = append(words, joinWords(amount, "to", capitalize(strings.ToLower(ability))))
+	} else if amount != "" {
+		words = append(words, amount)
+	}
+	for _, k := range sortedKeys(attributes) {
+		if !slices.Contains(MODIFIER_AMOUNTS, k) && !slices.Contains(MODIFIER_SKIPPED, k) {
+			words = append(words, k + ": " + attributes[k])
+		}
+	}
+	return strings.Join(words, ", ")
+}
+
+// revealed wraps the HTML of a tag that is only shown with -reveal in its label.
+func revealed(e element, content string) string {
+	label := revealLabel(newNode(e))
+	if isInline(newNode(e)) && !strings.Contains(content, "<div") && !strings.Contains(content, "<table") && !strings.Contains(content, "<p") {
+		return fmt.Sprintf(FMT_REVEALED, label, strings.TrimSpace(content))
+	}
+	return fmt.Sprintf(FMT_REVEALED_BLOCK, label, content)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// --- SAVED GAMES ---
+// With -import, the Adventure Sheet, the Ship's Manifest, the codewords and the section tickboxes
+// are filled in with a saved adventurer, to print a snapshot of a game.
+// The saved game can be a JAFL one, which is XML, or one saved by the 'play' command, which is JSON.
+// Rather than following the layout of one version of JAFL, importSave looks for the elements
+// and attributes it knows wherever they are in the file:
+//  - adventurer: name, profession, god, rank, stamina, staminaMax, shards (or gold, money)
+//    and the abilities, as attributes or as child elements
+//  - weapon, armour, tool, item: the possessions
+//  - codeword, title, blessing: by their name attribute or their text
+//  - resurrection: the resurrection arrangement
+//  - ship: type, name, crew, docked, with its cargo elements
+//  - section: the ticks of its tickboxes, in the book of its book attribute or of the enclosing book element
+
+// An element of the saved game, waiting for its end to be imported with its text
+type saveElement struct {
+	name string
+	attributes map[string]string
+	text string
+}
+
+func importSave(filename string) (a Adventurer, err error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return loadAdventurer(filename)
+	}
+
+	a = newAdventurer(Profession{})
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	var open []saveElement
+	for {
+		token, tokenErr := decoder.Token()
+		if tokenErr == io.EOF {
+			break
+		} else if tokenErr != nil {
+			err = fmt.Errorf("%s is not a JAFL saved game: %w", filename, tokenErr)
+			return
+		}
+		switch t := token.(type) {
+			case xml.StartElement:
+				e := saveElement{name: strings.ToLower(t.Name.Local), attributes: make(map[string]string)}
+				for _, attr := range t.Attr {
+					e.attributes[strings.ToLower(attr.Name.Local)] = attr.Value
+				}
+				if e.name == "ship" {
+					a.Ships = append(a.Ships, Ship{})
+				}
+				open = append(open, e)
+			case xml.CharData:
+				if len(open) > 0 {
+					open[len(open)-1].text += string(t)
+				}
+			case xml.EndElement:
+				if len(open) > 0 {
+					e := open[len(open)-1]
+					open = open[:len(open)-1]
+					a.importElement(e, open)
+				}
+		}
+	}
+	if a.Name == "" && a.Profession == "" {
+		err = fmt.Errorf("Found no adventurer in %s", filename)
+	}
+	a.MaxStamina = max(a.MaxStamina, a.Stamina)
+	return
+}
+
+// importElement copies what an element of a saved game says into the adventurer.
+func (a *Adventurer) importElement(e saveElement, parents []saveElement) {
+	text := strings.TrimSpace(e.text)
+	value := func(keys ...string) string {
+		for _, k := range keys {
+			if v := e.attributes[k]; v != "" {
+				return v
+			}
+		}
+		return text
+	}
+	inside := func(name string) *saveElement {
+		for i := len(parents) - 1; i >= 0; i-- {
+			if parents[i].name == name {
+				return &parents[i]
+			}
+		}
+		return nil
+	}
+
+	switch e.name {
+		case "adventurer", "character", "player":
+			a.importAttributes(e.attributes)
+
+		case "name", "profession", "god", "rank", "stamina", "staminamax", "shards", "gold", "money":
+			if inside("ship") == nil && text != "" {
+				a.importAttributes(map[string]string{e.name: text})
+			}
+
+		case "ability":
+			if ab := abilityName(e.attributes["name"]); ab != "" {
+				a.Abilities[ab] = atoi(value("value", "score", "amount"))
+			}
+
+		case "charisma", "combat", "magic", "sanctity", "scouting", "thievery":
+			a.Abilities[abilityName(e.name)] = atoi(value("value", "score", "amount"))
+
+		case "weapon", "armour", "tool", "item":
+			if inside("ship") != nil {
+				break
+			}
+			a.Possessions = append(a.Possessions, Item{
+				Name: capitalize(value("name")),
+				Type: e.name,
+				Bonus: e.attributes["bonus"],
+				Ability: e.attributes["ability"],
+			})
+
+		case "cargo":
+			if inside("ship") != nil && len(a.Ships) > 0 {
+				ship := &a.Ships[len(a.Ships)-1]
+				ship.Cargo = append(ship.Cargo, capitalize(value("name", "type")))
+			}
+
+		case "ship":
+			ship := &a.Ships[len(a.Ships)-1]
+			ship.Type = capitalize(e.attributes["type"])
+			ship.Name = e.attributes["name"]
+			ship.Crew = e.attributes["crew"]
+			ship.Docked = value("docked", "dock", "location", "port")
+			if ship.Docked == text {
+				ship.Docked = ""
+			}
+
+		case "codeword":
+			if word := value("name"); word != "" && !a.HasCodeword(word) {
+				a.Codewords = append(a.Codewords, capitalize(word))
+			}
+
+		case "title":
+			if title := value("name"); title != "" {
+				a.Titles = append(a.Titles, title)
+			}
+
+		case "blessing":
+			if blessing := value("type", "name"); blessing != "" {
+				a.Blessings = append(a.Blessings, capitalize(blessing))
+			}
+
+		case "resurrection":
+			r := e.attributes
+			a.Resurrection = value("text", "name", "god")
+			if r["section"] != "" {
+				a.Resurrection = joinWords(a.Resurrection, "(Book " + r["book"] + ", section " + r["section"] + ")")
+				a.Temple = newTarget(r["book"], r["section"])
+			}
+
+		case "section":
+			ticks := atoi(value("ticks", "ticked", "tickboxes"))
+			bk := e.attributes["book"]
+			if b := inside("book"); bk == "" && b != nil {
+				bk = b.attributes["number"]
+				if bk == "" {
+					bk = b.attributes["book"]
+				}
+			}
+			if ticks > 0 && bk != "" {
+				a.Ticks[bk + "-" + e.attributes["name"]] = ticks
+			}
+	}
+}
+
+// importAttributes reads the scores of an adventurer, written like "9" or "7/9" for Stamina.
+func (a *Adventurer) importAttributes(attributes map[string]string) {
+	for _, k := range sortedKeys(attributes) {
+		v := attributes[k]
+		current, maximum, _ := strings.Cut(v, "/")
+		switch k {
+			case "name":
+				a.Name = v
+			case "profession":
+				a.Profession = capitalize(strings.ToLower(v))
+			case "god":
+				a.God = capitalize(v)
+			case "rank":
+				a.Rank = atoi(v)
+			case "stamina":
+				a.Stamina = atoi(current)
+				if maximum != "" {
+					a.MaxStamina = atoi(maximum)
+				}
+			case "staminamax", "maxstamina":
+				a.MaxStamina = atoi(v)
+			case "shards", "gold", "money":
+				a.Shards = atoi(v)
+			default:
+				if ab := abilityName(k); ab != "" {
+					a.Abilities[ab] = atoi(v)
+				}
+		}
+	}
+}
+
+// --- FILLING THE HTML PAGES ---
+
+var sheetCellPattern = regexp.MustCompile(`<th[^>]*>([^<]*)</th>|<td class="(field|possession)[^"]*"></td>`)
+var manifestCellPattern = regexp.MustCompile(`<td([^>]*)></td>`)
+
+// fillSheet writes the imported adventurer in the cells of Sheet.html.
+// Without one, the sheet is left blank, but for the link to the Temples report.
+func fillSheet(page string) string {
+	if hero == nil {
+		if *templesReport && isHTMLSheet(page) {
+			return fillSheetWith(page, nil)
+		}
+		return page
+	}
+	if !isHTMLSheet(page) {
+		fmt.Print("(the Adventure Sheet is a picture, use the Sheet.html in src to fill it in) ")
+		return page
+	}
+	return fillSheetWith(page, hero)
+}
+
+// isHTMLSheet tells whether a sheet has cells to fill in, rather than being a picture.
+func isHTMLSheet(page string) bool {
+	return strings.Contains(page, "<td")
+}
+
+// fillSheetWith writes an adventurer in the cells of Sheet.html, each field under its label.
+func fillSheetWith(page string, a *Adventurer) string {
+	var labels []string
+	possessions := 0
+	return sheetCellPattern.ReplaceAllStringFunc(page, func(m string) string {
+		match := sheetCellPattern.FindStringSubmatch(m)
+		switch {
+			case match[2] == "possession":
+				possessions++
+				return strings.Replace(m, "></td>", ">" + html.EscapeString(possessionValue(a, possessions - 1)) + "</td>", 1)
+			case match[2] == "field" && len(labels) > 0:
+				value := html.EscapeString(sheetValue(a, labels[0]))
+				if t, text := sheetLink(a, labels[0]); t != nil {
+					value = fmt.Sprintf(FMT_REPORT_LINK, t.ID, html.EscapeString(text))
+				}
+				labels = labels[1:]
+				return strings.Replace(m, "></td>", ">" + value + "</td>", 1)
+			case match[1] != "":
+				label := strings.TrimSpace(match[1])
+				if i := slices.IndexFunc(SHEET_FIELDS, func(f string) bool { return strings.EqualFold(f, label) }); i >= 0 {
+					labels = append(labels, SHEET_FIELDS[i])
+				} else if strings.EqualFold(label, SHEET_BLESSINGS) {
+					labels = append(labels, SHEET_BLESSINGS)
+				}
+		}
+		return m
+	})
+}
+
+// fillManifest writes the ships of the imported adventurer in the rows of Manifest.html.
+func fillManifest(page string) string {
+	if hero == nil || len(hero.Ships) == 0 {
+		return page
+	}
+	if !isHTMLSheet(page) {
+		fmt.Print("(the Ship's Manifest is a picture, use the Manifest.html in src to fill it in) ")
+		return page
+	}
+	cell := 0
+	return manifestCellPattern.ReplaceAllStringFunc(page, func(m string) string {
+		row := manifestRow(cell / len(MANIFEST_COLUMNS))
+		value := row[cell % len(MANIFEST_COLUMNS)]
+		cell++
+		return strings.Replace(m, "></td>", ">" + html.EscapeString(value) + "</td>", 1)
+	})
+}
+
+// fillCodewords ticks the codewords of the imported adventurer in a Codewords page.
+func fillCodewords(page string) string {
+	if hero == nil {
+		return page
+	}
+	return codewordPattern.ReplaceAllStringFunc(page, func(m string) string {
+		word := codewordPattern.FindStringSubmatch(m)[1]
+		if codewordBox(word) == TICKED {
+			return `<li class="ticked">` + word + "</li>"
+		}
+		return m
+	})
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+)
+
+// --- STATS ---
+// The 'stats' command counts what the books are made of, book by book and in total,
+// from the model that parse() builds: sections, choices, fights, markets and so on.
+// It is meant to compare the design of the books.
+
+// Tags that give an item, unless they have a price. 'gain' gives one when it has an 'item' attribute.
+var STATS_ITEM_TAGS = []string{"item", "weapon", "armour", "tool", "ship", "cargo"}
+
+type BookStats struct {
+	Book int `json:"book,omitempty"`
+	Title string `json:"title"`
+	Sections int `json:"sections"`
+	Choices int `json:"choices"`
+	Outcomes int `json:"outcomes"`	// Tables of random outcomes
+	Fights int `json:"fights"`
+	Markets int `json:"markets"`
+	Codewords int `json:"codewords"`	// Codewords granted
+	Items int `json:"items"`	// Items found, outside of markets
+	Tickboxes int `json:"tickboxes"`	// Sections with tickboxes
+	Images int `json:"images"`
+	Words int `json:"words"`
+	ChoicesPerSection float64 `json:"choicesPerSection"`
+}
+
+type Stats struct {
+	Books []BookStats `json:"books"`
+	Total BookStats `json:"total"`
+}
+
+func stats(args []string) {
+	flags := flag.NewFlagSet(COMMAND_STATS, flag.ExitOnError)
+	statsFormat := flags.String("format", FORMAT_TEXT, "Output format: txt or json")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: jaflToHtml stats [flags] <directory> [output file]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	switch *statsFormat {
+		case FORMAT_TEXT, FORMAT_JSON:
+		default:
+			usageError(flags, fmt.Errorf("Unknown output format %q", *statsFormat))
+	}
+
+	root = flags.Arg(0)
+	if root == "" {
+		root = DEFAULT_DIR
+	}
+	progress = io.Discard
+	var s Stats
+	s.Total.Title = "Total"
+	for i, d := range listBooks() {
+		bk := loadBook(i+1, stripExt(d), io.Discard)
+		b := bookStats(bk)
+		s.Books = append(s.Books, b)
+		s.Total.add(b)
+	}
+	s.Total.average()
+
+	out := os.Stdout
+	if flags.Arg(1) != "" {
+		file, err := os.Create(flags.Arg(1))
+		check(err)
+		defer file.Close()
+		out = file
+	}
+	if *statsFormat == FORMAT_JSON {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "\t")
+		check(encoder.Encode(s))
+	} else {
+		fmt.Fprint(out, statsTable(s))
+	}
+}
+
+// bookStats counts the parts of a book.
+func bookStats(bk Book) (b BookStats) {
+	b.Book, b.Title = bk.Number, bk.Title
+	b.Sections = len(bk.Sections)
+	for _, s := range bk.Sections {
+		if s.Boxes > 0 {
+			b.Tickboxes++
+		}
+		b.Words += len(sectionWords(s))
+		for _, n := range allNodes(s.Content) {
+			switch n.Type {
+				case NODE_CHOICE:
+					b.Choices++
+				case NODE_OUTCOMES:
+					b.Outcomes++
+				case NODE_FIGHT:
+					b.Fights++
+				case NODE_MARKET:
+					b.Markets++
+				case NODE_IMAGE:
+					b.Images++
+				case NODE_TICK:
+					if n.Codeword != "" {
+						b.Codewords++
+					}
+				case NODE_ITEM:
+					if n.Price == nil && (slices.Contains(STATS_ITEM_TAGS, n.Tag) || n.Tag == "gain" && n.Attributes["item"] != "") {
+						b.Items++
+					}
+			}
+		}
+	}
+	b.average()
+	return
+}
+
+func (b *BookStats) add(other BookStats) {
+	b.Sections += other.Sections
+	b.Choices += other.Choices
+	b.Outcomes += other.Outcomes
+	b.Fights += other.Fights
+	b.Markets += other.Markets
+	b.Codewords += other.Codewords
+	b.Items += other.Items
+	b.Tickboxes += other.Tickboxes
+	b.Images += other.Images
+	b.Words += other.Words
+}
+
+func (b *BookStats) average() {
+	if b.Sections > 0 {
+		b.ChoicesPerSection = float64(b.Choices) / float64(b.Sections)
+	}
+}
+
+// statsTable lays out the stats with a column for each book, and one for the total.
+func statsTable(s Stats) string {
+	columns := append(s.Books, s.Total)
+	rows := [][]string{{""}}
+	for _, b := range columns {
+		if b.Book > 0 {
+			rows[0] = append(rows[0], "Book " + strconv.Itoa(b.Book))
+		} else {
+			rows[0] = append(rows[0], b.Title)
+		}
+	}
+	for _, line := range []struct {
+		label string
+		value func(b BookStats) string
+	}{
+		{"Sections", func(b BookStats) string { return strconv.Itoa(b.Sections) }},
+		{"Choices", func(b BookStats) string { return strconv.Itoa(b.Choices) }},
+		{"Choices per section", func(b BookStats) string { return fmt.Sprintf("%.2f", b.ChoicesPerSection) }},
+		{"Random outcome tables", func(b BookStats) string { return strconv.Itoa(b.Outcomes) }},
+		{"Fights", func(b BookStats) string { return strconv.Itoa(b.Fights) }},
+		{"Markets", func(b BookStats) string { return strconv.Itoa(b.Markets) }},
+		{"Codewords granted", func(b BookStats) string { return strconv.Itoa(b.Codewords) }},
+		{"Items", func(b BookStats) string { return strconv.Itoa(b.Items) }},
+		{"Sections with tickboxes", func(b BookStats) string { return strconv.Itoa(b.Tickboxes) }},
+		{"Images", func(b BookStats) string { return strconv.Itoa(b.Images) }},
+		{"Words", func(b BookStats) string { return strconv.Itoa(b.Words) }},
+	} {
+		row := []string{line.label}
+		for _, b := range columns {
+			row = append(row, line.value(b))
+		}
+		rows = append(rows, row)
+	}
+	out := ""
+	for _, b := range s.Books {
+		out += fmt.Sprintf("Book %d: %s\n", b.Book, b.Title)
+	}
+	return out + "\n" + asciiTable(rows, *width)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// --- UNKNOWN TAGS ---
+// Tags that replace() does not know are left as they are, which leaks them into the HTML.
+// They are counted with the sections they are found in, and listed at the end of the conversion.
+// A mapping file, passed with -tags, tells how to render them instead. It is a JSON object
+// whose keys are tag names, like:
+//
+//	{
+//		"weird": {"action": "drop"},
+//		"aside": {"action": "unwrap"},
+//		"note": {"action": "wrap", "element": "div", "class": "note"},
+//		"stat": {"action": "template", "template": "<b>{{index .Attributes \"name\"}}</b>: {{.Content}}"}
+//	}
+//
+// Templates get the Name, Attributes and Content (already converted) of the tag.
+// The other formats drop the tags whose action is 'drop', and print the content of the others.
+
+const (
+	TAG_DROP = "drop"
+	TAG_UNWRAP = "unwrap"
+	TAG_WRAP = "wrap"
+	TAG_TEMPLATE = "template"
+)
+
+// Tags that are left as they are on purpose: paragraphs, HTML formatting, and the parts of a 'group'
+var TAG_PASSTHROUGH = []string{"p", "i", "b", "em", "strong", "u", "br", "sup", "sub", "small", "text"}
+
+// Most sections listed for an unknown tag
+const TAG_LOCATIONS = 10
+
+type TagRule struct {
+	Action string `json:"action"`
+	Element string `json:"element,omitempty"`
+	Class string `json:"class,omitempty"`
+	Template string `json:"template,omitempty"`
+	parsed *template.Template
+}
+
+type unknownTag struct {
+	name string
+	count int
+	locations []string
+}
+
+// Rules of the mapping file, by tag
+var tagRules = make(map[string]TagRule)
+
+// Unknown tags found so far, by tag
+var unknownTags = make(map[string]*unknownTag)
+
+// Unknown tag found by parse(), before it is counted
+type tagLocation struct {
+	name string
+	location string
+}
+
+// loadTagRules reads the mapping file.
+func loadTagRules(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var rules map[string]TagRule
+	if err = json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	for _, name := range sortedKeys(rules) {
+		rule := rules[name]
+		switch rule.Action {
+			case TAG_DROP, TAG_UNWRAP:
+			case TAG_WRAP:
+				if rule.Element == "" {
+					rule.Element = "span"
+				}
+			case TAG_TEMPLATE:
+				rule.parsed, err = template.New(name).Parse(rule.Template)
+				if err != nil {
+					return fmt.Errorf("%s: template of %q: %w", filename, name, err)
+				}
+			default:
+				return fmt.Errorf("%s: unknown action %q for %q (use drop, unwrap, wrap or template)", filename, rule.Action, name)
+		}
+		tagRules[name] = rule
+	}
+	return nil
+}
+
+// render converts a tag as its rule says.
+func (rule TagRule) render(e element) string {
+	switch rule.Action {
+		case TAG_UNWRAP:
+			return e.Content
+		case TAG_WRAP:
+			class := ""
+			if rule.Class != "" {
+				class = ` class="` + rule.Class + `"`
+			}
+			return "<" + rule.Element + class + ">" + e.Content + "</" + rule.Element + ">"
+		case TAG_TEMPLATE:
+			var out strings.Builder
+			check(rule.parsed.Execute(&out, struct {
+				Name string
+				Attributes map[string]string
+				Content string
+			}{e.Name, e.Attributes, e.Content}))
+			return out.String()
+	}
+	return ""
+}
+
+// dropped tells whether the mapping file drops a tag.
+func dropped(n Node) bool {
+	return n.Type == NODE_ELEMENT && tagRules[n.Tag].Action == TAG_DROP
+}
+
+// stackSection is the section a tag of the stack is in, or nothing outside of sections.
+func stackSection(s stack) string {
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i].Name == SECTION {
+			if book == 0 {
+				return s[i].Attributes["name"]
+			}
+			return sectionID(s[i])
+		}
+	}
+	return ""
+}
+
+// noteUnknownTag keeps a tag that replace() does not know, with the section of the file it is in.
+func (p *parsed) noteUnknownTag(name string) {
+	if p == nil || slices.Contains(TAG_PASSTHROUGH, name) {
+		return
+	}
+	location := p.section
+	if location == "" {
+		location = filepath.Base(p.filename)
+	}
+	p.unknown = append(p.unknown, tagLocation{name, location})
+}
+
+// countUnknownTags counts the unknown tags of a file. Files are counted in the order of the output,
+// so that the sections of a tag are listed in that order whichever file was parsed first.
+func countUnknownTags(p parsed) {
+	for _, found := range p.unknown {
+		t, ok := unknownTags[found.name]
+		if !ok {
+			t = &unknownTag{name: found.name}
+			unknownTags[found.name] = t
+		}
+		t.count++
+		if !slices.Contains(t.locations, found.location) {
+			t.locations = append(t.locations, found.location)
+		}
+	}
+}
+
+// printUnknownTags lists the unknown tags, the most frequent first.
+func printUnknownTags(w io.Writer) {
+	if len(unknownTags) == 0 {
+		return
+	}
+	var tags []*unknownTag
+	for _, t := range unknownTags {
+		tags = append(tags, t)
+	}
+	slices.SortFunc(tags, func(a, b *unknownTag) int {
+		if a.count != b.count {
+			return b.count - a.count
+		}
+		return strings.Compare(a.name, b.name)
+	})
+	fmt.Fprintln(w, "\nUnknown tags, left as they are (pass a mapping file with -tags to render them):")
+	for _, t := range tags {
+		locations := strings.Join(t.locations[:min(len(t.locations), TAG_LOCATIONS)], ", ")
+		if len(t.locations) > TAG_LOCATIONS {
+			locations += fmt.Sprintf(" and %d more", len(t.locations) - TAG_LOCATIONS)
+		}
+		fmt.Fprintf(w, "  <%s>: %d (%s)\n", t.name, t.count, locations)
+	}
+}
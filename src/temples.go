@@ -0,0 +1,102 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// --- TEMPLES ---
+// With -temples, the 'Temples' report lists, for every god, the sections where resurrection can be arranged
+// and the other places where the god is worshipped, with the cost found in the text around them.
+
+var costPattern = regexp.MustCompile(`(?i)(\d+)\s+Shards?`)
+
+// Anchor of the report, and the text of the link to it on a blank Adventure Sheet
+const (
+	TEMPLES_ID = "temples"
+	TXT_SEE_TEMPLES = "See the Temples"
+)
+
+type temple struct {
+	god string
+	section Section
+	location string
+	service string
+	cost string
+	resurrection *Target	// Section where the adventurer comes back to life
+}
+
+// templeCost finds the price of a service in the paragraph that holds it, or else in the paragraphs
+// right after and before it. The rest of the section is not searched: its prices are often those of a market or a fine.
+func templeCost(content []Node, i int) string {
+	for _, j := range []int{i, i+1, i-1} {
+		if j < 0 || j >= len(content) {
+			continue
+		}
+		if m := costPattern.FindStringSubmatch(plainText(content[j:j+1])); m != nil {
+			return m[1] + " Shards"
+		}
+	}
+	return ""
+}
+
+// temples gathers the temples of the books, by god in alphabetical order, then in the order of the sections.
+func temples(document Document) (found []temple) {
+	for _, bk := range document.Books {
+		for _, s := range bk.Sections {
+			for i, top := range s.Content {
+				for _, n := range allNodes([]Node{top}) {
+					t := temple{section: s, location: sectionLocation(s, bk)}
+					switch {
+						case n.Type == NODE_RESURRECTION:
+							r := n.Resurrection
+							t.god, t.service = r.God, "Resurrection"
+							if r.Text != "" {
+								t.service += ": " + r.Text
+							}
+							book := r.Book
+							if book == 0 {
+								book = bk.Number
+							}
+							if r.Section != "" {
+								t.resurrection = &Target{Book: book, Section: r.Section, ID: strconv.Itoa(book) + "-" + r.Section}
+							}
+						// Becoming an initiate, making an offering... name the god in an attribute,
+						// but conditions on the god of the adventurer are not places of worship
+						case n.Attributes["god"] != "" && n.Tag != "if" && n.Tag != "lose":
+							t.god, t.service = n.Attributes["god"], "Worship"
+						default:
+							continue
+					}
+					t.cost = templeCost(s.Content, i)
+					found = append(found, t)
+				}
+			}
+		}
+	}
+	slices.SortStableFunc(found, func(a, b temple) int {
+		return strings.Compare(strings.ToLower(a.god), strings.ToLower(b.god))
+	})
+	return
+}
+
+// templeDirectory lists the temples of the books, one table for each god.
+func templeDirectory(document Document) (r Report) {
+	r.ID = TEMPLES_ID
+	r.Title = "Temples"
+	r.Intro = "Every place where resurrection can be arranged or a god worshipped, with its cost."
+	for _, t := range temples(document) {
+		if len(r.Tables) == 0 || !strings.EqualFold(r.Tables[len(r.Tables)-1].Title, t.god) {
+			r.Tables = append(r.Tables, ReportTable{Title: t.god, Header: []string{"Section", "Location", "Service", "Cost", "Resurrected in"}})
+		}
+		var resurrection ReportCell
+		if t.resurrection != nil {
+			resurrection = ReportCell{Text: t.resurrection.Section + " (" + bookTitle(t.resurrection.Book) + ")", Target: t.resurrection}
+		}
+		table := &r.Tables[len(r.Tables)-1]
+		table.Rows = append(table.Rows, []ReportCell{bookSectionCell(t.section), {Text: t.location}, {Text: t.service}, {Text: t.cost}, resurrection})
+	}
+	return
+}
@@ -0,0 +1,721 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// --- PLAIN TEXT AND GEMTEXT ---
+// Both formats are rendered from the book model by the same writer.
+// Plain text is wrapped to -width columns and lays tables out with ASCII art.
+// Gemtext leaves the wrapping to the client, puts tables in preformatted blocks,
+// and turns every choice into a link line. Since Gemini clients do not follow anchors,
+// the Gemtext output is a directory with one page per section, and the images copied next to them.
+
+const DEFAULT_WIDTH = 80
+const MIN_COLUMN = 8
+
+const GEMTEXT_INDEX = "index.gmi"
+const GEMTEXT_EXT = ".gmi"
+const GEMTEXT_IMAGES = "images"
+
+type textWriter struct {
+	out strings.Builder
+	para string
+	links []string
+	gemini bool
+	width int
+	dir string
+	book *Book
+	images map[string]string	// Copies in the capsule of the images, by their path in the books
+}
+
+func writeText(filename string, document Document) error {
+	w := textWriter{width: *width}
+
+	w.title("FABLED LANDS")
+	for _, s := range document.Rules {
+		w.section(s)
+	}
+	for i := range document.Books {
+		w.book = &document.Books[i]
+		w.title(strings.ToUpper(w.book.Title))
+		for _, s := range w.book.Sections {
+			w.section(s)
+		}
+	}
+	w.book = nil
+	w.sheet("Adventure Sheet", hero)
+	w.manifest()
+	w.codewords()
+	w.pregenerated(document)
+	for _, r := range reports(document) {
+		w.report(r)
+	}
+
+	return os.WriteFile(filename, []byte(w.out.String()), 0644)
+}
+
+func writeGemtext(dirname string, document Document) error {
+	w := textWriter{gemini: true, dir: dirname, images: make(map[string]string)}
+	err := os.MkdirAll(dirname, 0755)
+	if err != nil {
+		return err
+	}
+
+	// Index
+	w.out.WriteString("# Fabled Lands\n\n")
+	for _, s := range document.Rules {
+		w.out.WriteString(fmt.Sprintf("=> %s %s\n", gemtextLink(s.ID), s.Name))
+	}
+	for _, bk := range document.Books {
+		w.out.WriteString(fmt.Sprintf("=> %s %s\n", gemtextLink(fmt.Sprintf("book-%d", bk.Number)), bk.Title))
+	}
+	w.out.WriteString("\n=> " + gemtextLink("sheet") + " Adventure Sheet\n")
+	w.out.WriteString("=> " + gemtextLink("manifest") + " Ship's Manifest\n")
+	w.out.WriteString("=> " + gemtextLink("codewords") + " Codewords\n")
+	if *pregenerated {
+		w.out.WriteString("=> " + gemtextLink("pregenerated") + " " + PREGENERATED_TITLE + "\n")
+	}
+	for _, r := range reports(document) {
+		w.out.WriteString("=> " + gemtextLink(r.ID) + " " + r.Title + "\n")
+	}
+	if err = w.save(GEMTEXT_INDEX); err != nil {
+		return err
+	}
+
+	for _, s := range document.Rules {
+		w.section(s)
+		if err = w.save(gemtextLink(s.ID)); err != nil {
+			return err
+		}
+	}
+
+	for i := range document.Books {
+		w.book = &document.Books[i]
+		w.out.WriteString("# " + w.book.Title + "\n\n")
+		w.image(w.book.Map, w.book.Region + " map")
+		for _, s := range w.book.Sections {
+			w.out.WriteString(fmt.Sprintf("=> %s %s\n", gemtextLink(s.ID), s.Name))
+		}
+		if err = w.save(gemtextLink(fmt.Sprintf("book-%d", w.book.Number))); err != nil {
+			return err
+		}
+		for _, s := range w.book.Sections {
+			w.section(s)
+			w.out.WriteString("\n=> " + gemtextLink(fmt.Sprintf("book-%d", w.book.Number)) + " " + w.book.Title + "\n")
+			if err = w.save(gemtextLink(s.ID)); err != nil {
+				return err
+			}
+		}
+	}
+	w.book = nil
+
+	w.sheet("Adventure Sheet", hero)
+	if err = w.save(gemtextLink("sheet")); err != nil {
+		return err
+	}
+	w.manifest()
+	if err = w.save(gemtextLink("manifest")); err != nil {
+		return err
+	}
+	w.codewords()
+	if err = w.save(gemtextLink("codewords")); err != nil {
+		return err
+	}
+	if *pregenerated {
+		w.pregenerated(document)
+		if err = w.save(gemtextLink("pregenerated")); err != nil {
+			return err
+		}
+	}
+	for _, r := range reports(document) {
+		w.report(r)
+		if err = w.save(gemtextLink(r.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save writes the current page of a Gemtext capsule and starts a new one.
+func (w *textWriter) save(name string) error {
+	if name != GEMTEXT_INDEX {
+		w.out.WriteString("\n=> " + GEMTEXT_INDEX + " Fabled Lands\n")
+	}
+	name, _ = url.PathUnescape(name)
+	err := os.WriteFile(filepath.Join(w.dir, name), []byte(w.out.String()), 0644)
+	w.out.Reset()
+	return err
+}
+
+func gemtextLink(id string) string {
+	return url.PathEscape(id) + GEMTEXT_EXT
+}
+
+func (w *textWriter) title(s string) {
+	line := strings.Repeat("#", utf8.RuneCountInString(s) + 4)
+	w.out.WriteString("\n" + line + "\n# " + s + " #\n" + line + "\n\n")
+}
+
+func (w *textWriter) heading(s string) {
+	if w.gemini {
+		w.out.WriteString("## " + s + "\n\n")
+	} else {
+		w.out.WriteString("\n" + s + "\n" + strings.Repeat("=", utf8.RuneCountInString(s)) + "\n\n")
+	}
+}
+
+func (w *textWriter) section(s Section) {
+	name := s.Name
+	name += sectionBoxes(s.ID, s.Boxes)
+	w.heading(name)
+	if s.Profession != "" && w.book != nil {
+		for _, p := range w.book.Professions {
+			if p.Name == s.Profession {
+				w.profession(p)
+			}
+		}
+	}
+	w.nodes(s.Content)
+	w.flush()
+}
+
+// inline adds text to the pending paragraph.
+func (w *textWriter) inline(s string) {
+	w.para += s
+}
+
+// flush writes the pending paragraph, followed by the links it contained in Gemtext.
+func (w *textWriter) flush() {
+	if p := strings.Join(strings.Fields(w.para), " "); p != "" {
+		if w.gemini {
+			w.out.WriteString(p + "\n")
+		} else {
+			w.out.WriteString(wrap(p, w.width) + "\n")
+		}
+		for _, l := range w.links {
+			w.out.WriteString(l + "\n")
+		}
+		w.out.WriteString("\n")
+	}
+	w.para = ""
+	w.links = nil
+}
+
+// block writes a table, preformatted in Gemtext.
+func (w *textWriter) block(s string) {
+	w.flush()
+	if w.gemini {
+		s = "```\n" + s + "```\n"
+	}
+	w.out.WriteString(s + "\n")
+}
+
+func (w *textWriter) link(t *Target, label string) {
+	if w.gemini {
+		w.links = append(w.links, "=> " + gemtextLink(t.ID) + " " + label)
+	}
+}
+
+// image links to a picture in Gemtext, copied into the capsule the first time so that it is served with the pages.
+func (w *textWriter) image(path, label string) {
+	if w.gemini {
+		w.flush()
+		name, ok := w.images[path]
+		if !ok {
+			name = fmt.Sprintf("%s/%d%s", GEMTEXT_IMAGES, len(w.images) + 1, strings.ToLower(filepath.Ext(path)))
+			if err := copyImage(path, filepath.Join(w.dir, name)); err != nil {
+				fmt.Println("Could not copy image:", err)
+				return
+			}
+			w.images[path] = name
+		}
+		w.out.WriteString("=> " + name + " " + label + "\n\n")
+	} else {
+		w.inline("[" + label + "]")
+		w.flush()
+	}
+}
+
+func copyImage(from, to string) error {
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(to, data, 0644)
+}
+
+func (w *textWriter) nodes(nodes []Node) {
+	for _, n := range nodes {
+		w.node(n)
+	}
+}
+
+func (w *textWriter) node(n Node) {
+	if !visible(n) {
+		return
+	}
+	// Paragraphs start with their label, the other blocks are preceded by it
+	label := blockLabel(n)
+	if label != "" && n.Type != NODE_PARAGRAPH {
+		w.inline(textStyle(CLASS_REVEALED, label) + " ")
+	}
+	switch n.Type {
+		case NODE_PARAGRAPH:
+			w.flush()
+			if label != "" {
+				w.inline(textStyle(CLASS_REVEALED, label) + " ")
+			}
+			w.nodes(n.Children)
+			w.flush()
+
+		case NODE_MARKET:
+			rows := [][]string{{"Item", "Buy Price", "Sell Price"}}
+			for _, c := range n.Children {
+				switch {
+					case !visible(c):
+					case c.Type == NODE_HEADER:
+						rows = append(rows, []string{c.Text})
+					case c.Price != nil:
+						buy, sell := c.Price.Buy, c.Price.Sell
+						if buy == "" {
+							buy = "-"
+						}
+						if sell == "" {
+							sell = "-"
+						}
+						rows = append(rows, []string{w.inlineNode(c), buy, sell})
+					default:
+						rows = append(rows, []string{w.inlineNode(c)})
+				}
+			}
+			w.block(asciiTable(rows, w.tableWidth()))
+
+		case NODE_CHOICES, NODE_OUTCOMES:
+			var rows [][]string
+			w.flush()
+			for _, c := range n.Children {
+				switch {
+					case !visible(c):
+					case isRow(c):
+						text := w.inlineNodes(c.Children)
+						turnTo := ""
+						if c.Target != nil {
+							turnTo = fmt.Sprintf(TXT_TURNTO, targetLabel(c))
+						}
+						if w.gemini {
+							label := joinWords(rowLabel(c), text, turnTo)
+							if c.Target != nil {
+								w.out.WriteString("=> " + gemtextLink(c.Target.ID) + " " + label + "\n")
+							} else {
+								w.out.WriteString("* " + label + "\n")
+							}
+						} else {
+							rows = append(rows, []string{rowLabel(c), text, turnTo})
+						}
+					default:
+						text := w.inlineNode(c)
+						switch {
+							case text == "":
+							case w.gemini:
+								w.out.WriteString("* " + text + "\n")
+							default:
+								rows = append(rows, []string{text})
+						}
+				}
+			}
+			if w.gemini {
+				w.out.WriteString("\n")
+			} else if len(rows) > 0 {
+				w.block(asciiTable(rows, w.tableWidth()))
+			}
+
+		case NODE_FIGHT:
+			f := n.Fight
+			w.block(asciiTable([][]string{
+				{f.Name},
+				{fmt.Sprintf("Combat: %d", f.Combat), fmt.Sprintf("Defence: %d", f.Defence), fmt.Sprintf("Stamina: %d", f.Stamina)},
+			}, w.tableWidth()))
+			if *combat && fightHasOdds(bookProfessions(w.book), *f) {
+				w.inline(fightOddsText(bookProfessions(w.book), *f))
+				w.flush()
+			}
+
+		case NODE_IMAGE:
+			if w.book != nil {
+				w.image(filepath.Join(w.book.Dir, n.File), "Image")
+			}
+
+		case NODE_CACHE:
+			w.flush()
+			if n.Tag == "moneycache" {
+				w.inline("Please write the amount in your sheet instead.")
+				w.flush()
+			} else {
+				w.block(asciiTable([][]string{{n.Text}, {"\n\n\n"}}, w.tableWidth()))
+			}
+
+		case NODE_HEADER:
+			w.inline(n.Text)
+			w.flush()
+
+		case NODE_NOTE:
+			// Only shown with -reveal, in a paragraph of its own
+			w.flush()
+			w.inline(w.inlineNode(n))
+			w.flush()
+
+		case NODE_CONDITION, NODE_ELEMENT:
+			if hasBlocks(n.Children) {
+				if n.Type == NODE_CONDITION {
+					w.inline(condition(n.Attributes, plainText(n.Children), textStyle))
+				}
+				w.nodes(n.Children)
+			} else {
+				w.inline(w.inlineNode(n))
+			}
+
+		default:
+			w.inline(w.inlineNode(n))
+	}
+}
+
+func (w *textWriter) inlineNodes(nodes []Node) (out string) {
+	for _, n := range nodes {
+		out += w.inlineNode(n)
+	}
+	return strings.Join(strings.Fields(out), " ")
+}
+
+func (w *textWriter) inlineNode(n Node) (out string) {
+	if !visible(n) {
+		return
+	}
+	if label := inlineLabel(n); label != "" {
+		// Whatever the node turns out to be, the label goes in front of it
+		defer func() {
+			out = textStyle(CLASS_REVEALED, label) + " " + out
+		}()
+	}
+	if n.Type == NODE_TEXT {
+		return n.Text
+	}
+	if isRow(n) {
+		// A branch option that is not inside a table
+		var turnTo string
+		if n.Target != nil {
+			turnTo = fmt.Sprintf(TXT_TURNTO, targetLabel(n))
+			w.link(n.Target, joinWords(rowLabel(n), turnTo))
+		}
+		return " " + joinWords(rowLabel(n), w.inlineNodes(n.Children), turnTo) + " "
+	}
+	if s, ok := wording(n, bookProfessions(w.book), textStyle); ok {
+		out = s
+	} else {
+		switch n.Type {
+			case NODE_FIGHT:
+				out = fmt.Sprintf("%s (Combat %d, Defence %d, Stamina %d)", n.Fight.Name, n.Fight.Combat, n.Fight.Defence, n.Fight.Stamina)
+			case NODE_GROUP:
+				for _, c := range n.Children {
+					if c.Tag == "text" {
+						out += w.inlineNodes(c.Children)
+					}
+				}
+			case NODE_CONDITION:
+				out = joinWords(condition(n.Attributes, plainText(n.Children), textStyle), w.inlineNodes(n.Children))
+			default:
+				for _, c := range n.Children {
+					out += w.inlineNode(c)
+				}
+		}
+	}
+	if n.Target != nil && !isRow(n) {
+		w.link(n.Target, fmt.Sprintf(TXT_TURNTO, targetLabel(n)))
+	}
+	return
+}
+
+func textStyle(class, text string) string {
+	return text
+}
+
+func (w *textWriter) tableWidth() int {
+	if w.gemini {
+		return DEFAULT_WIDTH
+	}
+	return w.width
+}
+
+func (w *textWriter) profession(p Profession) {
+	w.inline(p.Name)
+	w.flush()
+	rows := [][]string{ABILITIES, p.Abilities, {"Stamina", "Rank", "Gold"}, {p.Stamina, p.Rank, p.Gold}, {"Starting equipment"}}
+	for _, e := range p.Equipment {
+		rows = append(rows, []string{capitalize(e.Type), equipmentName(e)})
+	}
+	w.block(asciiTable(rows, w.tableWidth()))
+}
+
+func (w *textWriter) sheet(title string, a *Adventurer) {
+	w.heading(title)
+	var rows [][]string
+	for _, f := range SHEET_FIELDS {
+		rows = append(rows, []string{f, sheetValue(a, f)})
+	}
+	for i := 1; i <= SHEET_POSSESSIONS; i++ {
+		rows = append(rows, []string{fmt.Sprintf("Possession %d", i), possessionValue(a, i-1)})
+	}
+	rows = append(rows, []string{SHEET_BLESSINGS, sheetValue(a, SHEET_BLESSINGS) + "\n\n"})
+	w.block(asciiTable(rows, w.tableWidth()))
+}
+
+// pregenerated adds a filled-in Adventure Sheet for every starting adventurer.
+func (w *textWriter) pregenerated(document Document) {
+	adventurers := pregeneratedAdventurers(document)
+	if len(adventurers) == 0 {
+		return
+	}
+	if w.gemini {
+		w.out.WriteString("# " + PREGENERATED_TITLE + "\n\n")
+	} else {
+		w.title(strings.ToUpper(PREGENERATED_TITLE))
+	}
+	for i := range adventurers {
+		w.sheet(adventurerTitle(adventurers[i]), &adventurers[i])
+	}
+}
+
+// report lays out the tables of a report, followed by the links to their sections in Gemtext.
+func (w *textWriter) report(r Report) {
+	if w.gemini {
+		w.out.WriteString("# " + r.Title + "\n\n")
+	} else {
+		w.title(strings.ToUpper(r.Title))
+	}
+	if r.Intro != "" {
+		w.inline(r.Intro)
+		w.flush()
+	}
+	for _, t := range r.Tables {
+		if t.Title != "" {
+			w.heading(t.Title)
+		}
+		var rows [][]string
+		var links []string
+		if len(t.Header) > 0 {
+			rows = append(rows, t.Header)
+		}
+		for _, row := range t.Rows {
+			var cells []string
+			for _, c := range row {
+				cells = append(cells, c.plain())
+				for _, p := range c.parts() {
+					if p.Target != nil {
+						links = append(links, "=> " + gemtextLink(p.Target.ID) + " " + fmt.Sprintf(TXT_TURNTO, p.Text))
+					}
+				}
+			}
+			rows = append(rows, cells)
+		}
+		w.block(asciiTable(rows, w.tableWidth()))
+		if w.gemini && len(links) > 0 {
+			w.out.WriteString(strings.Join(links, "\n") + "\n\n")
+		}
+	}
+}
+
+func (w *textWriter) manifest() {
+	w.heading("Ship's Manifest")
+	rows := [][]string{MANIFEST_COLUMNS}
+	for i := 0; i < MANIFEST_ROWS; i++ {
+		rows = append(rows, manifestRow(i))
+	}
+	w.block(asciiTable(rows, w.tableWidth()))
+}
+
+func (w *textWriter) codewords() {
+	w.heading("Codewords")
+	for _, n := range codewordBooks() {
+		w.inline(bookTitle(n))
+		w.flush()
+		for _, word := range readCodewords(n) {
+			if w.gemini {
+				w.out.WriteString("* ")
+			}
+			w.out.WriteString(codewordBox(word) + " " + word + "\n")
+		}
+		w.out.WriteString("\n")
+	}
+}
+
+// wrap breaks a paragraph into lines no longer than width. Words that are longer are broken too.
+func wrap(text string, width int) string {
+	width = max(width, 1)
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(text) {
+		if line != "" && utf8.RuneCountInString(line) + 1 + utf8.RuneCountInString(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		for runes := []rune(word); len(runes) > width; runes = []rune(word) {
+			lines = append(lines, string(runes[:width]))
+			word = string(runes[width:])
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// asciiTable lays rows out as an ASCII table no wider than width, wrapping the cells that do not fit.
+// A row with a single cell spans the whole table. When the columns cannot fit even at MIN_COLUMN,
+// they are stacked as label/value rows.
+func asciiTable(rows [][]string, width int) (out string) {
+	var columns int
+	for _, r := range rows {
+		columns = max(columns, len(r))
+	}
+	if columns == 0 {
+		return
+	}
+
+	// Measure the columns, then shrink the widest until the table fits
+	widths := make([]int, columns)
+	for _, r := range rows {
+		if len(r) == 1 && columns > 1 {
+			continue
+		}
+		for i, c := range r {
+			for _, l := range strings.Split(c, "\n") {
+				widths[i] = max(widths[i], utf8.RuneCountInString(l))
+			}
+		}
+	}
+	// Tables of two columns, which cannot be stacked, are shrunk further
+	narrowest := MIN_COLUMN
+	for {
+		total := 1
+		widest := 0
+		for i, w := range widths {
+			total += w + 3
+			if w > widths[widest] {
+				widest = i
+			}
+		}
+		if total <= width {
+			break
+		}
+		if widths[widest] <= narrowest {
+			if columns > 2 {
+				return asciiTable(stackRows(rows), width)
+			}
+			if narrowest == 1 {
+				break
+			}
+			narrowest = 1
+			continue
+		}
+		widths[widest]--
+	}
+	full := -3
+	for _, w := range widths {
+		full += w + 3
+	}
+	// Spanning rows may be wider than all the columns together
+	for _, r := range rows {
+		if len(r) == 1 && columns > 1 {
+			for _, l := range strings.Split(r[0], "\n") {
+				if extra := utf8.RuneCountInString(l) - full; extra > 0 {
+					widths[columns-1] += min(extra, max(0, width - full - 4))
+					full += min(extra, max(0, width - full - 4))
+				}
+			}
+		}
+	}
+
+	separator := "+"
+	for _, w := range widths {
+		separator += strings.Repeat("-", w + 2) + "+"
+	}
+	out = separator + "\n"
+	for _, r := range rows {
+		cellWidths := widths
+		if len(r) == 1 && columns > 1 {
+			cellWidths = []int{full}
+		}
+		var cells [][]string
+		height := 1
+		for i, w := range cellWidths {
+			var c string
+			if i < len(r) {
+				c = r[i]
+			}
+			var lines []string
+			for _, l := range strings.Split(c, "\n") {
+				lines = append(lines, strings.Split(wrap(l, w), "\n")...)
+			}
+			cells = append(cells, lines)
+			height = max(height, len(lines))
+		}
+		for l := 0; l < height; l++ {
+			out += "|"
+			for i, w := range cellWidths {
+				var text string
+				if l < len(cells[i]) {
+					text = cells[i][l]
+				}
+				out += " " + text + strings.Repeat(" ", max(0, w - utf8.RuneCountInString(text))) + " |"
+			}
+			out += "\n"
+		}
+		out += separator + "\n"
+	}
+	return
+}
+
+// stackRows turns the rows of a table into label/value rows, for a table too wide to fit.
+// A row labels the rows of the same length under it, and a row that labels nothing is listed a cell per row.
+// Rows of one or two cells are kept as they are.
+func stackRows(rows [][]string) (stacked [][]string) {
+	var labels []string
+	labelling := false
+	unused := func() {
+		if !labelling {
+			for _, l := range labels {
+				stacked = append(stacked, []string{l})
+			}
+		}
+		labels = nil
+	}
+	for _, r := range rows {
+		switch {
+			case len(r) <= 2:
+				unused()
+				stacked = append(stacked, r)
+			case len(r) != len(labels):
+				unused()
+				labels, labelling = r, false
+			default:
+				for i, c := range r {
+					stacked = append(stacked, []string{labels[i], c})
+				}
+				labelling = true
+		}
+	}
+	unused()
+	return
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// --- TRADE ---
+// With -trade, the 'Trade' report gathers the prices of every market of the books, and of the
+// 'buy' and 'sell' tags found in the text, then lists the cargo runs that make the most money:
+// buying a cargo in one place and selling it in another.
+// With -trade-csv, the same prices and runs are exported as CSV, for use in a spreadsheet.
+
+const TRADE_ROUTES = 20
+
+type tradePrice struct {
+	section Section
+	location string
+	item Item
+	buy, sell string
+}
+
+type tradeRoute struct {
+	from, to tradePrice
+	profit int
+}
+
+// sectionLocation is the place a section is in, taken from its description.
+// Sections without one are placed in the region of their book.
+func sectionLocation(s Section, bk Book) string {
+	for _, n := range findNodes(s.Content, NODE_NOTE) {
+		if n.Tag == "desc" {
+			if desc := plainText(n.Children); desc != "" {
+				return desc
+			}
+		}
+	}
+	return bk.Region
+}
+
+// tradePrices lists every price of the books, in the order of the sections.
+func tradePrices(document Document) (prices []tradePrice) {
+	for _, bk := range document.Books {
+		for _, s := range bk.Sections {
+			for _, n := range findNodes(s.Content, NODE_ITEM) {
+				p := tradePrice{section: s, location: sectionLocation(s, bk), item: *n.Item}
+				switch {
+					case n.Price != nil:
+						p.buy, p.sell = n.Price.Buy, n.Price.Sell
+					// 'buy' and 'sell' tags keep their price in 'shards'
+					case n.Tag == "buy" && n.Attributes["shards"] != "":
+						p.buy = n.Attributes["shards"]
+					case n.Tag == "sell" && n.Attributes["shards"] != "":
+						p.sell = n.Attributes["shards"]
+					default:
+						continue
+				}
+				prices = append(prices, p)
+			}
+		}
+	}
+	return
+}
+
+// tradeRoutes lists the cargo runs that make money, the best first.
+func tradeRoutes(prices []tradePrice) (routes []tradeRoute) {
+	for _, from := range prices {
+		buy, err := strconv.Atoi(strings.TrimSpace(from.buy))
+		if from.item.Type != "cargo" || err != nil {
+			continue
+		}
+		for _, to := range prices {
+			sell, err := strconv.Atoi(strings.TrimSpace(to.sell))
+			if to.item.Type != "cargo" || err != nil || to.section.ID == from.section.ID || !strings.EqualFold(to.item.Name, from.item.Name) {
+				continue
+			}
+			if sell > buy {
+				routes = append(routes, tradeRoute{from: from, to: to, profit: sell - buy})
+			}
+		}
+	}
+	slices.SortStableFunc(routes, func(a, b tradeRoute) int {
+		return b.profit - a.profit
+	})
+	return
+}
+
+func tradeTables(document Document) (prices, routes ReportTable) {
+	all := tradePrices(document)
+
+	prices = ReportTable{Title: "Prices", Header: []string{"Item", "Type", "Book", "Section", "Location", "Buy", "Sell"}, Sortable: true}
+	sorted := slices.Clone(all)
+	slices.SortStableFunc(sorted, func(a, b tradePrice) int {
+		return strings.Compare(strings.ToLower(a.item.Name), strings.ToLower(b.item.Name))
+	})
+	for _, p := range sorted {
+		prices.Rows = append(prices.Rows, []ReportCell{{Text: p.item.Name}, {Text: p.item.Type}, {Text: strconv.Itoa(p.section.Book)},
+			sectionCell(p.section), {Text: p.location}, {Text: p.buy}, {Text: p.sell}})
+	}
+
+	routes = ReportTable{Title: "Most profitable cargo runs", Header: []string{"Cargo", "Buy in", "Location", "Price", "Sell in", "Location", "Price", "Profit"}, Sortable: true}
+	found := tradeRoutes(all)
+	for _, r := range found[:min(len(found), TRADE_ROUTES)] {
+		routes.Rows = append(routes.Rows, []ReportCell{{Text: r.from.item.Name}, bookSectionCell(r.from.section), {Text: r.from.location}, {Text: r.from.buy},
+			bookSectionCell(r.to.section), {Text: r.to.location}, {Text: r.to.sell}, {Text: strconv.Itoa(r.profit)}})
+	}
+	return
+}
+
+// trade is the report of the prices of the books and of the best cargo runs.
+func trade(document Document) (r Report) {
+	r.ID = "trade"
+	r.Title = "Trade"
+	r.Intro = fmt.Sprintf("The prices of every market of the books, and the %d cargo runs that make the most Shards, buying in one place and selling in another.", TRADE_ROUTES)
+	prices, routes := tradeTables(document)
+	r.Tables = append(r.Tables, prices, routes)
+	return
+}
+
+// writeTradeCSV saves the prices in a CSV file, and the cargo runs in another one named after it.
+func writeTradeCSV(filename string, document Document) error {
+	prices, routes := tradeTables(document)
+	if err := writeCSV(filename, prices); err != nil {
+		return err
+	}
+	return writeCSV(stripExt(filename) + "-routes.csv", routes)
+}
+
+// writeCSV saves a report table as CSV, with the text of its cells.
+func writeCSV(filename string, t ReportTable) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write(t.Header)
+	for _, row := range t.Rows {
+		var record []string
+		for _, c := range row {
+			record = append(record, c.plain())
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return w.Error()
+}
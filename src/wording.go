@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// --- PLAIN WORDING ---
+// The formats that are not built by replace() render the book model instead.
+// These helpers give them the same wording replace() uses, and leave the markup to a styler.
+
+// A styler wraps a piece of text in the markup of an output format.
+// It is also responsible for escaping the text.
+type styler func(class, text string) string
+
+const (
+	CLASS_PLAIN = ""
+	CLASS_ITEM = "item"
+	CLASS_TURNTO = "turn-to"
+	CLASS_RESURRECTION = "resurrection"
+)
+
+// wording gives the stock text of a node that has no content of its own, with the odds of the starting professions of its book.
+// ok is false for the nodes that must be rendered from their children instead.
+func wording(n Node, professions []Profession, style styler) (out string, ok bool) {
+	if len(n.Children) > 0 {
+		return
+	}
+	ok = true
+	switch n.Type {
+		case NODE_ITEM:
+			out = style(CLASS_ITEM, itemName(element{Name: n.Tag, Attributes: n.Attributes}))
+		case NODE_GOTO:
+			out = style(CLASS_TURNTO, fmt.Sprintf(TXT_TURNTO, targetLabel(n)))
+		case NODE_ROLL:
+			out = style(CLASS_PLAIN, fmt.Sprintf(FMT_ROLL, strconv.Itoa(n.Check.Dice)))
+			if n.Tag == "rankcheck" {
+				out += style(CLASS_PLAIN, TXT_RANKCHECK)
+			}
+		case NODE_CHECK:
+			out = style(CLASS_PLAIN, fmt.Sprintf(FMT_CHECK, n.Check.Ability, strconv.Itoa(n.Check.Level)))
+			if *odds && len(professions) > 0 {
+				out += style(CLASS_PLAIN, fmt.Sprintf(TXT_ODDS, checkOdds(professions, n.Check.Ability, n.Check.Level)))
+			}
+		case NODE_TICK:
+			if n.Codeword == "" {
+				out = style(CLASS_PLAIN, TXT_TICK_BOX)
+			} else {
+				out = style(CLASS_PLAIN, TXT_TICK_CODEWORD) + style(CLASS_ITEM, n.Codeword)
+			}
+		case NODE_RESURRECTION:
+			r := n.Resurrection
+			out = style(CLASS_RESURRECTION, fmt.Sprintf(TXT_RESURRECTION, r.God, n.Attributes["book"], r.Section, r.Text))
+		case NODE_DISEASE:
+			out = style(CLASS_PLAIN, n.Text)
+		case NODE_REROLL:
+			out = style(CLASS_PLAIN, TXT_REROLL)
+		case NODE_RETURN:
+			out = style(CLASS_PLAIN, TXT_RETURN)
+		default:
+			ok = false
+	}
+	return
+}
+
+// targetLabel is the section a node points to, with the book title when it is in another book.
+func targetLabel(n Node) string {
+	if n.Target == nil {
+		return ""
+	}
+	if n.Attributes["book"] != "" {
+		return n.Target.Section + " (" + bookTitle(n.Target.Book) + ")"
+	}
+	return n.Target.Section
+}
+
+// rowLabel is the first cell of a branch option row.
+func rowLabel(n Node) string {
+	switch {
+		case n.Tag == "success" || n.Tag == "failure":
+			return capitalize(n.Tag)
+		default:
+			return n.Range
+	}
+}
+
+// isRow tells whether a branch option is laid out as a table row.
+func isRow(n Node) bool {
+	return (n.Type == NODE_CHOICE || n.Type == NODE_OUTCOME) && (n.Target != nil || n.Type == NODE_OUTCOME)
+}
+
+// visible tells whether a node shows up in the printed book.
+func visible(n Node) bool {
+	if dropped(n) {
+		return false
+	}
+	return *reveal || (!n.Hidden && n.Type != NODE_NOTE)
+}
+
+// joinWords puts the non-empty parts together, separated by spaces.
+func joinWords(parts ...string) string {
+	var words []string
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			words = append(words, p)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func bookTitle(n int) string {
+	if n < 0 || n >= len(title) {
+		return "undefined"
+	}
+	return title[n]
+}
+
+// --- CONDITIONS ---
+// 'if' tags tell the game when their content applies. Their attributes are put into words
+// in front of the content, so that the printed books keep the condition.
+
+// Attributes of 'if' tags, in the order their conditions are told
+var CONDITION_KEYS = []string{"profession", "god", "codeword", "title", "blessing", "item", "weapon", "armour", "tool", "ship", "cargo",
+	"disease", "curse", "poison", "resurrection", "ability", "rank", "stamina", "shards", "dock", "docked"}
+
+// Wording of the conditions, by attribute
+var CONDITION_WORDS = map[string]string{
+	"profession": "you are %s",
+	"god": "you are an initiate of %s",
+	"codeword": "you have the codeword %s",
+	"title": "you have the title %s",
+	"blessing": "you have the blessing %s",
+	"item": "you have %s",
+	"weapon": "you have %s",
+	"armour": "you have %s",
+	"tool": "you have %s",
+	"ship": "you have %s",
+	"cargo": "you have %s",
+	"disease": "you have %s",
+	"curse": "you have %s",
+	"poison": "you have %s",
+	"resurrection": "you have a resurrection arrangement",
+	"rank": "your Rank is %s or more",
+	"stamina": "your Stamina is %s or more",
+	"shards": "you have %s Shards",
+	"dock": "you have a ship docked at %s",
+	"docked": "you have a ship docked at %s",
+}
+
+// Attributes whose values are codewords and items, which the books print in their own font
+var CONDITION_ITEMS = []string{"codeword", "item", "weapon", "armour", "tool", "ship", "cargo"}
+
+// condition puts the attributes of an 'if' tag into words, like "If you have the codeword Aspen:".
+// Several values separated by '|' are alternatives, and not="t" turns the condition around.
+// It is empty when the content already tells the condition, as in "If you have Aspen, turn to 2",
+// and when the tag has no attribute it knows.
+func condition(attributes map[string]string, content string, style styler) string {
+	content = strings.ToLower(strings.TrimSpace(content))
+	if strings.HasPrefix(content, "if ") || strings.HasPrefix(content, "unless ") {
+		return ""
+	}
+	var conditions []string
+	for _, k := range CONDITION_KEYS {
+		v, ok := attributes[k]
+		if !ok {
+			continue
+		}
+		var values []string
+		for _, alternative := range strings.Split(v, "|") {
+			alternative = strings.TrimSpace(alternative)
+			switch {
+				case slices.Contains(CONDITION_ITEMS, k):
+					values = append(values, style(CLASS_ITEM, capitalize(alternative)))
+				case k == "profession":
+					values = append(values, style(CLASS_PLAIN, article(alternative) + " " + capitalize(alternative)))
+				default:
+					values = append(values, style(CLASS_PLAIN, alternative))
+			}
+		}
+		value := strings.Join(values, style(CLASS_PLAIN, " or "))
+		if k == "ability" {
+			level := attributes["level"]
+			if level == "" {
+				level = attributes["amount"]
+			}
+			if level == "" {
+				continue
+			}
+			conditions = append(conditions, style(CLASS_PLAIN, "your ") + style(CLASS_PLAIN, capitalize(strings.ToLower(v))) + style(CLASS_PLAIN, " score is " + level + " or more"))
+			continue
+		}
+		// The words are split around the value, so that only the value gets its style
+		before, after, _ := strings.Cut(CONDITION_WORDS[k], "%s")
+		conditions = append(conditions, style(CLASS_PLAIN, before) + value + style(CLASS_PLAIN, after))
+	}
+	if len(conditions) == 0 {
+		return ""
+	}
+	opening := "If "
+	if attributes["not"] == "t" {
+		opening = "Unless "
+	}
+	return style(CLASS_PLAIN, opening) + strings.Join(conditions, style(CLASS_PLAIN, " and ")) + style(CLASS_PLAIN, ":")
+}
+
+// htmlStyle is the styler of replace(). It works on the markup of the books, which is already escaped.
+func htmlStyle(class, text string) string {
+	switch class {
+		case CLASS_ITEM:
+			return fmt.Sprintf(FMT_ITEM, text)
+		case CLASS_PLAIN:
+			return text
+		default:
+			return `<span class="` + class + `">` + text + `</span>`
+	}
+}
+
+// article is "a" or "an", for the word that follows.
+func article(word string) string {
+	if word != "" && strings.ContainsRune("AEIOUaeiou", rune(word[0])) {
+		return "an"
+	}
+	return "a"
+}
+
+// --- APPENDICES ---
+
+var SHEET_FIELDS = []string{"Name", "Profession", "God", "Rank", "Stamina", "Defence", "Money",
+	"Charisma", "Combat", "Magic", "Sanctity", "Scouting", "Thievery",
+	"Resurrection arrangement", "Titles and honours"}
+const SHEET_POSSESSIONS = 12
+const SHEET_BLESSINGS = "Blessings"
+
+var MANIFEST_COLUMNS = []string{"Ship Type", "Name", "Crew Quality", "Cargo Capacity", "Current Cargo", "Where Docked"}
+const MANIFEST_ROWS = 16
+
+var codewordPattern = regexp.MustCompile(`<li>\s*(.*?)\s*</li>`)
+
+// The adventurer imported with -import, whose saved game fills in the appendices and the tickboxes
+var hero *Adventurer
+
+// bookProfessions are the starting professions of a book, and there are none outside of the books.
+func bookProfessions(bk *Book) []Profession {
+	if bk == nil {
+		return nil
+	}
+	return bk.Professions
+}
+
+// sheetValue is the content of a field of the Adventure Sheet, which is blank without an adventurer.
+func sheetValue(a *Adventurer, field string) string {
+	if a == nil {
+		return ""
+	}
+	return a.sheetValues()[field]
+}
+
+// sheetLink is where a field of the sheet points to, and the text of the link: the temple of the resurrection
+// arrangement, or else, with -temples, the Temples report to choose one from. A report is a Target without a section.
+func sheetLink(a *Adventurer, field string) (*Target, string) {
+	text := sheetValue(a, field)
+	switch {
+		case field != "Resurrection arrangement":
+			return nil, text
+		case a != nil && a.Temple != nil:
+			return a.Temple, text
+		case *templesReport:
+			if text == "" {
+				text = TXT_SEE_TEMPLES
+			}
+			return &Target{ID: TEMPLES_ID}, text
+	}
+	return nil, text
+}
+
+// possessionValue is the content of a line of the Possessions box, counted from 0.
+func possessionValue(a *Adventurer, i int) string {
+	if a == nil || i >= len(a.Possessions) {
+		return ""
+	}
+	return equipmentName(a.Possessions[i])
+}
+
+// manifestRow is the content of a row of the Ship's Manifest, counted from 0.
+func manifestRow(i int) []string {
+	if hero == nil || i >= len(hero.Ships) {
+		return make([]string, len(MANIFEST_COLUMNS))
+	}
+	return hero.Ships[i].manifestRow()
+}
+
+// codewordBox is the box in front of a codeword in the Codewords appendix.
+func codewordBox(word string) string {
+	if hero != nil && hero.HasCodeword(word) {
+		return TICKED
+	}
+	return TICKBOX
+}
+
+// sectionBoxes are the tickboxes next to the title of a section.
+func sectionBoxes(id string, boxes int) (out string) {
+	ticked := 0
+	if hero != nil {
+		ticked = hero.Ticks[id]
+	}
+	for i := 0; i < boxes; i++ {
+		if i < ticked {
+			out += " " + TICKED
+		} else {
+			out += " " + TICKBOX
+		}
+	}
+	return
+}
+
+// codewordBooks lists the books whose codewords go in the appendix.
+func codewordBooks() (books []int) {
+	if *b != 0 {
+		return []int{*b}
+	}
+	for i := 1; i <= 6; i++ {
+		books = append(books, i)
+	}
+	return
+}
+
+// readCodewords gets the list of codewords of a book out of its Codewords page.
+func readCodewords(n int) (words []string) {
+	raw, err := os.ReadFile(fmt.Sprintf(CODEWORDS_NAME, strconv.Itoa(n)))
+	check(err)
+	for _, m := range codewordPattern.FindAllStringSubmatch(string(raw), -1) {
+		words = append(words, strings.TrimSpace(m[1]))
+	}
+	return
+}
+
+// --- MARKUP HELPERS ---
+
+func escapeXML(s string) string {
+	var out strings.Builder
+	xml.EscapeText(&out, []byte(s))
+	return out.String()
+}
+
+func contentType(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+		case ".png":
+			return "image/png"
+		case ".gif":
+			return "image/gif"
+		default:
+			return "image/jpeg"
+	}
+}